@@ -1,6 +1,11 @@
 package api
 
 import (
+	"strconv"
+
+	"github.com/Dataman-Cloud/hamal/src/audit"
+	"github.com/Dataman-Cloud/hamal/src/auth"
+	"github.com/Dataman-Cloud/hamal/src/config"
 	"github.com/Dataman-Cloud/hamal/src/models"
 	"github.com/Dataman-Cloud/hamal/src/service"
 	"github.com/Dataman-Cloud/hamal/src/utils"
@@ -16,16 +21,46 @@ const (
 	UpdateError        = "503-10004"
 	GetAppError        = "503-10005"
 	GetAppVersionError = "503-10006"
+	PlanError          = "503-10007"
+	VersionDiffError   = "503-10008"
+	RevertError        = "503-10009"
+	ApproveError       = "503-10010"
+	PauseError         = "503-10011"
+	Forbidden          = "403-10013"
+	AuditError         = "503-10014"
+	NamespaceError     = "503-10015"
 )
 
 type HamalControl struct {
 	Service *service.HamalService
+	// Policy authorizes the authenticated callers, they may do everything
+	// when it is nil
+	Policy *auth.Policy
+	// Audit records who changed what through the api
+	Audit audit.Log
 }
 
 func InitHamalControl() *HamalControl {
-	return &HamalControl{
+	hc := &HamalControl{
 		Service: service.InitHamalService(),
 	}
+	auditLog, err := audit.NewFileLog(config.GetConfig().AuditLog)
+	if err != nil {
+		log.Fatalf("open audit log error: %v", err)
+		return nil
+	}
+	hc.Audit = auditLog
+	// the changes hamal makes in swan on its own are recorded as well
+	hc.Service.Audit = auditLog
+	if path := config.GetConfig().PolicyFile; path != "" {
+		policy, err := auth.NewPolicy(path)
+		if err != nil {
+			log.Fatalf("load policy file error: %v", err)
+			return nil
+		}
+		hc.Policy = policy
+	}
+	return hc
 }
 
 func (hc *HamalControl) Ping(ctx *gin.Context) {
@@ -34,9 +69,21 @@ func (hc *HamalControl) Ping(ctx *gin.Context) {
 
 func (hc *HamalControl) CreateOrUpdateProject(ctx *gin.Context) {
 	var project models.Project
-	if err := ctx.BindJSON(&project); err != nil {
-		log.Error("invalid param")
-		utils.ErrorResponse(ctx, utils.NewError(ParamError, "invalid param"))
+	if !bindProject(ctx, &project) {
+		return
+	}
+	if !hc.authorize(ctx, auth.ActionDeploy, project.Namespace, project.Name, project.Labels) {
+		return
+	}
+
+	if ctx.Query("dry_run") == "true" {
+		plan, err := hc.Service.DryRunProject(&project)
+		if err != nil {
+			log.Error(err)
+			utils.ErrorResponse(ctx, utils.NewError(PlanError, err))
+			return
+		}
+		utils.Ok(ctx, plan)
 		return
 	}
 
@@ -50,9 +97,12 @@ func (hc *HamalControl) CreateOrUpdateProject(ctx *gin.Context) {
 
 func (hc *HamalControl) UpdateProject(ctx *gin.Context) {
 	var project models.Project
-	if err := ctx.BindJSON(&project); err != nil {
-		log.Error("invalid param")
-		utils.ErrorResponse(ctx, utils.NewError(ParamError, "invalid param"))
+	if !bindProject(ctx, &project) {
+		return
+	}
+	// the labels may move the project to other callers
+	if !hc.authorizeProject(ctx, auth.ActionDeploy, project.Namespace, project.Name) ||
+		!hc.authorize(ctx, auth.ActionDeploy, project.Namespace, project.Name, project.Labels) {
 		return
 	}
 
@@ -65,12 +115,28 @@ func (hc *HamalControl) UpdateProject(ctx *gin.Context) {
 }
 
 func (hc *HamalControl) GetProjects(ctx *gin.Context) {
-	projects := hc.Service.GetProjects()
-	utils.Ok(ctx, projects)
+	projects, err := hc.Service.GetProjects()
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, err)
+		return
+	}
+	namespace := routeNamespace(ctx)
+	visible := make([]*models.Project, 0, len(projects))
+	for _, project := range projects {
+		if project.InNamespace(namespace) &&
+			hc.allowed(ctx, auth.ActionView, namespace, project.Name, project.Labels) {
+			visible = append(visible, project)
+		}
+	}
+	utils.Ok(ctx, visible)
 }
 
 func (hc *HamalControl) DeleteProjects(ctx *gin.Context) {
-	if err := hc.Service.DeleteProject(ctx.Param("name")); err != nil {
+	if !hc.authorizeRoute(ctx, auth.ActionAdmin) {
+		return
+	}
+	if err := hc.Service.DeleteProject(routeProject(ctx)); err != nil {
 		log.Error(err)
 		utils.ErrorResponse(ctx, utils.NewError(ProjectNotExist, err))
 		return
@@ -79,7 +145,10 @@ func (hc *HamalControl) DeleteProjects(ctx *gin.Context) {
 }
 
 func (hc *HamalControl) GetProject(ctx *gin.Context) {
-	project, err := hc.Service.GetProject(ctx.Param("name"))
+	if !hc.authorizeRoute(ctx, auth.ActionView) {
+		return
+	}
+	project, err := hc.Service.GetProject(routeProject(ctx))
 	if err != nil {
 		log.Error(err)
 		utils.ErrorResponse(ctx, utils.NewError(ProjectNotExist, err))
@@ -88,8 +157,37 @@ func (hc *HamalControl) GetProject(ctx *gin.Context) {
 	utils.Ok(ctx, project)
 }
 
+func (hc *HamalControl) Reconcile(ctx *gin.Context) {
+	if !hc.authorizeRoute(ctx, auth.ActionDeploy) {
+		return
+	}
+	project, err := hc.Service.ReconcileProject(routeProject(ctx))
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, utils.NewError(ProjectNotExist, err))
+		return
+	}
+	utils.Ok(ctx, project)
+}
+
+func (hc *HamalControl) Plan(ctx *gin.Context) {
+	if !hc.authorizeRoute(ctx, auth.ActionView) {
+		return
+	}
+	plan, err := hc.Service.Plan(routeProject(ctx))
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, utils.NewError(PlanError, err))
+		return
+	}
+	utils.Ok(ctx, plan)
+}
+
 func (hc *HamalControl) RollingUpdate(ctx *gin.Context) {
-	projectName := ctx.Param("name")
+	if !hc.authorizeRoute(ctx, auth.ActionDeploy) {
+		return
+	}
+	projectName := routeProject(ctx)
 	var data models.RollPolicy
 	if err := ctx.BindJSON(&data); err != nil {
 		utils.ErrorResponse(ctx, utils.NewError(ParamError, err))
@@ -111,17 +209,23 @@ func (hc *HamalControl) RollingUpdate(ctx *gin.Context) {
 }
 
 func (hc *HamalControl) GetApp(ctx *gin.Context) {
-	app, err := hc.Service.GetApp(ctx.Param("app_id"))
+	if !hc.authorizeApp(ctx, ctx.Param("app_id")) {
+		return
+	}
+	app, err := hc.Service.GetApp(ctx.Query("cluster"), ctx.Param("app_id"))
 	if err != nil {
 		utils.ErrorResponse(ctx, utils.NewError(GetAppError, err))
 		return
 	}
 
-	utils.Ok(ctx, app)
+	utils.Ok(ctx, service.MaskApp(app))
 }
 
 func (hc *HamalControl) Rollback(ctx *gin.Context) {
-	projectName := ctx.Param("name")
+	if !hc.authorizeRoute(ctx, auth.ActionDeploy) {
+		return
+	}
+	projectName := routeProject(ctx)
 	var data models.RollPolicy
 	if err := ctx.BindJSON(&data); err != nil {
 		utils.ErrorResponse(ctx, utils.NewError(ParamError, err))
@@ -142,8 +246,92 @@ func (hc *HamalControl) Rollback(ctx *gin.Context) {
 	utils.Ok(ctx, "success")
 }
 
+func (hc *HamalControl) Revert(ctx *gin.Context) {
+	if !hc.authorizeRoute(ctx, auth.ActionDeploy) {
+		return
+	}
+	var data models.RevertPolicy
+	if err := ctx.BindJSON(&data); err != nil {
+		utils.ErrorResponse(ctx, utils.NewError(ParamError, err))
+		return
+	}
+
+	if data.VersionId == "" {
+		utils.ErrorResponse(ctx, utils.NewError(ParamError, "invalid version_id"))
+		return
+	}
+
+	err := hc.Service.Revert(routeProject(ctx), ctx.Param("app_id"), data.VersionId)
+	if err != nil {
+		utils.ErrorResponse(ctx, utils.NewError(RevertError, err))
+		return
+	}
+	utils.Ok(ctx, "success")
+}
+
+func (hc *HamalControl) Approve(ctx *gin.Context) {
+	if !hc.authorizeRoute(ctx, auth.ActionApprove) {
+		return
+	}
+	stage, err := strconv.ParseInt(ctx.Param("n"), 10, 64)
+	if err != nil {
+		utils.ErrorResponse(ctx, utils.NewError(ParamError, "invalid stage"))
+		return
+	}
+	var data models.ApprovePolicy
+	if err := ctx.BindJSON(&data); err != nil {
+		utils.ErrorResponse(ctx, utils.NewError(ParamError, err))
+		return
+	}
+
+	// an authenticated caller approves in its own name
+	if identity := auth.GetIdentity(ctx); identity != nil {
+		if data.Approver != "" && data.Approver != identity.Name {
+			utils.ErrorResponse(ctx, utils.NewError(ParamError, "can not approve as "+data.Approver))
+			return
+		}
+		data.Approver = identity.Name
+	}
+	if data.Approver == "" {
+		utils.ErrorResponse(ctx, utils.NewError(ParamError, "invalid approver"))
+		return
+	}
+
+	approvals, err := hc.Service.Approve(routeProject(ctx), ctx.Param("app_id"), stage, data.Approver)
+	if err != nil {
+		utils.ErrorResponse(ctx, utils.NewError(ApproveError, err))
+		return
+	}
+	utils.Ok(ctx, approvals)
+}
+
+func (hc *HamalControl) PauseProject(ctx *gin.Context) {
+	hc.setPaused(ctx, true)
+}
+
+func (hc *HamalControl) ResumeProject(ctx *gin.Context) {
+	hc.setPaused(ctx, false)
+}
+
+// setPaused pauses or resumes the project, or the app when the route has one
+func (hc *HamalControl) setPaused(ctx *gin.Context, paused bool) {
+	if !hc.authorizeRoute(ctx, auth.ActionDeploy) {
+		return
+	}
+	project, err := hc.Service.SetPaused(routeProject(ctx), ctx.Param("app_id"), paused)
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, utils.NewError(PauseError, err))
+		return
+	}
+	utils.Ok(ctx, project)
+}
+
 func (hc *HamalControl) GetAppVersions(ctx *gin.Context) {
-	version, err := hc.Service.GetAppVersions(ctx.Param("app_id"))
+	if !hc.authorizeApp(ctx, ctx.Param("app_id")) {
+		return
+	}
+	version, err := hc.Service.GetAppVersions(ctx.Query("cluster"), ctx.Param("app_id"))
 	if err != nil {
 		utils.ErrorResponse(ctx, utils.NewError(GetAppVersionError, err))
 		return
@@ -151,3 +339,16 @@ func (hc *HamalControl) GetAppVersions(ctx *gin.Context) {
 
 	utils.Ok(ctx, version)
 }
+
+func (hc *HamalControl) DiffAppVersions(ctx *gin.Context) {
+	if !hc.authorizeApp(ctx, ctx.Param("app_id")) {
+		return
+	}
+	diff, err := hc.Service.DiffVersions(ctx.Query("cluster"), ctx.Param("app_id"), ctx.Query("from"), ctx.Query("to"))
+	if err != nil {
+		utils.ErrorResponse(ctx, utils.NewError(VersionDiffError, err))
+		return
+	}
+
+	utils.Ok(ctx, diff)
+}
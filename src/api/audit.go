@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/audit"
+	"github.com/Dataman-Cloud/hamal/src/auth"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+// MaxAuditResponse bounds the answer kept in an audit record
+const MaxAuditResponse = 2048
+
+// responseRecorder keeps the beginning of the response for the audit log
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	if left := MaxAuditResponse - w.body.Len(); left > 0 {
+		if len(data) < left {
+			left = len(data)
+		}
+		w.body.Write(data[:left])
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Audited records the requests handled by handler in the audit log under
+// the given action, whether they succeed, fail or are denied
+func (hc *HamalControl) Audited(action string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		payload, err := ioutil.ReadAll(ctx.Request.Body)
+		if err != nil {
+			utils.ErrorResponse(ctx, utils.NewError(ParamError, err))
+			return
+		}
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(payload))
+
+		record := &audit.Record{
+			Time:      time.Now().Format(time.RFC3339Nano),
+			Remote:    ctx.ClientIP(),
+			Action:    action,
+			Method:    ctx.Request.Method,
+			Path:      ctx.Request.URL.Path,
+			Namespace: routeNamespace(ctx),
+			Project:   ctx.Param("name"),
+			AppId:     ctx.Param("app_id"),
+		}
+		if identity := auth.GetIdentity(ctx); identity != nil {
+			record.Identity = identity.Name
+			record.Auth = identity.Method
+		}
+		if len(payload) > 0 {
+			sum := sha256.Sum256(payload)
+			record.PayloadSHA256 = hex.EncodeToString(sum[:])
+
+			var body struct {
+				Name  string `json:"name"`
+				AppId string `json:"app_id"`
+			}
+			if json.Unmarshal(payload, &body) == nil {
+				if record.Project == "" {
+					record.Project = body.Name
+				}
+				if record.AppId == "" {
+					record.AppId = body.AppId
+				}
+			}
+		}
+		if ctx.Query("dry_run") == "true" {
+			record.Action += "-dry-run"
+		}
+		if n, err := strconv.ParseInt(ctx.Param("n"), 10, 64); err == nil {
+			record.Stage = &n
+		}
+
+		recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+		handler(ctx)
+		ctx.Writer = recorder.ResponseWriter
+
+		record.Status = ctx.Writer.Status()
+		switch {
+		case record.Status == http.StatusForbidden:
+			record.Outcome = audit.OutcomeDenied
+		case record.Status < 200 || record.Status >= 300:
+			record.Outcome = audit.OutcomeFailure
+		default:
+			record.Outcome = audit.OutcomeSuccess
+		}
+		record.Response = strings.TrimSpace(recorder.body.String())
+		if record.Stage == nil && record.Project != "" && record.AppId != "" {
+			record.Stage = hc.startedStage(models.ProjectKey(record.Namespace, record.Project), record.AppId)
+		}
+
+		if err := hc.Audit.Append(record); err != nil {
+			// the change is done, it can not be refused any more
+			log.Errorf("audit log error, %s %s by %s not recorded: %v",
+				record.Action, record.Project, record.Identity, err)
+		}
+	}
+}
+
+// startedStage returns the last stage started of an app, nil before the
+// first one
+func (hc *HamalControl) startedStage(projectName, appId string) *int64 {
+	project, err := hc.Service.GetProject(projectName)
+	if err != nil {
+		return nil
+	}
+	for _, application := range project.Applications {
+		if application.AppId == appId && application.StagesStarted > 0 {
+			stage := application.StagesStarted - 1
+			return &stage
+		}
+	}
+	return nil
+}
+
+// GetAudit returns the audit records of a namespace selected by the query,
+// as json lines when format=jsonl. Only the admins of every project of the
+// namespace may read it, the default namespace unless namespace names one
+// or * for all.
+func (hc *HamalControl) GetAudit(ctx *gin.Context) {
+	namespace := ctx.DefaultQuery("namespace", models.DefaultNamespace)
+	if !hc.authorize(ctx, auth.ActionAdmin, namespace, "*", nil) {
+		return
+	}
+
+	filter := audit.Filter{
+		Identity: ctx.Query("identity"),
+		Action:   ctx.Query("action"),
+		Project:  ctx.Query("project"),
+		AppId:    ctx.Query("app_id"),
+		Outcome:  ctx.Query("outcome"),
+	}
+	if namespace != "*" {
+		filter.Namespace = namespace
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := ctx.Query(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				utils.ErrorResponse(ctx, utils.NewError(ParamError, "invalid "+param+", expect RFC 3339"))
+				return
+			}
+			*t = parsed
+		}
+	}
+	if v := ctx.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			utils.ErrorResponse(ctx, utils.NewError(ParamError, "invalid limit"))
+			return
+		}
+		filter.Limit = n
+	}
+
+	records, err := hc.Audit.Query(filter)
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, utils.NewError(AuditError, err))
+		return
+	}
+
+	if ctx.Query("format") != "jsonl" {
+		if records == nil {
+			records = []*audit.Record{}
+		}
+		utils.Ok(ctx, records)
+		return
+	}
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Header("Content-Disposition", `attachment; filename="hamal-audit.jsonl"`)
+	ctx.Status(http.StatusOK)
+	enc := json.NewEncoder(ctx.Writer)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			log.Errorf("audit export error: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"github.com/Dataman-Cloud/hamal/src/auth"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+// allowed reports whether the caller may do action on the project of the
+// namespace, every caller may when authentication or the policy is not
+// configured
+func (hc *HamalControl) allowed(ctx *gin.Context, action, namespace, project string, labels map[string]string) bool {
+	identity := auth.GetIdentity(ctx)
+	if identity == nil || hc.Policy == nil {
+		return true
+	}
+	return hc.Policy.Allowed(identity, action, namespace, project, labels)
+}
+
+// authorize checks that the caller may do action on the project of the
+// namespace with the given labels, it answers the request when not
+func (hc *HamalControl) authorize(ctx *gin.Context, action, namespace, project string, labels map[string]string) bool {
+	if hc.allowed(ctx, action, namespace, project, labels) {
+		return true
+	}
+	name := ""
+	if identity := auth.GetIdentity(ctx); identity != nil {
+		name = identity.Name
+	}
+	key := models.ProjectKey(namespace, project)
+	log.Warnf("%s is not allowed to %s project %s", name, action, key)
+	utils.ErrorResponse(ctx, utils.NewError(Forbidden, name+" is not allowed to "+action+" project "+key))
+	return false
+}
+
+// authorizeProject checks that the caller may do action on a stored
+// project. The policy decides on the name alone for a project which does
+// not exist, the handler then reports it missing.
+func (hc *HamalControl) authorizeProject(ctx *gin.Context, action, namespace, project string) bool {
+	labels, _ := hc.Service.ProjectLabels(models.ProjectKey(namespace, project))
+	return hc.authorize(ctx, action, namespace, project, labels)
+}
+
+// authorizeRoute checks that the caller may do action on the project of
+// the route
+func (hc *HamalControl) authorizeRoute(ctx *gin.Context, action string) bool {
+	return hc.authorizeProject(ctx, action, routeNamespace(ctx), ctx.Param("name"))
+}
+
+// authorizeApp checks that the caller may view a project rolling out the
+// app, an app of no project is only shown to the callers who may view
+// every project
+func (hc *HamalControl) authorizeApp(ctx *gin.Context, appId string) bool {
+	projects, err := hc.Service.AppProjects(appId)
+	if err != nil {
+		utils.ErrorResponse(ctx, utils.NewError(GetAppError, err))
+		return false
+	}
+	for _, project := range projects {
+		if hc.allowed(ctx, auth.ActionView, project.Namespace, project.Name, project.Labels) {
+			return true
+		}
+	}
+	return hc.authorize(ctx, auth.ActionView, "*", "*", nil)
+}
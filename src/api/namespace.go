@@ -0,0 +1,100 @@
+package api
+
+import (
+	"github.com/Dataman-Cloud/hamal/src/auth"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+// routeNamespace returns the namespace of the route, the routes which name
+// none address the default namespace
+func routeNamespace(ctx *gin.Context) string {
+	if namespace := ctx.Param("ns"); namespace != "" {
+		return namespace
+	}
+	return models.DefaultNamespace
+}
+
+// routeProject returns the key of the project of the route
+func routeProject(ctx *gin.Context) string {
+	return models.ProjectKey(routeNamespace(ctx), ctx.Param("name"))
+}
+
+// bindProject reads the project of the request body into the namespace of
+// the route, a project naming another namespace is refused
+func bindProject(ctx *gin.Context, project *models.Project) bool {
+	if err := ctx.BindJSON(project); err != nil {
+		log.Error("invalid param")
+		utils.ErrorResponse(ctx, utils.NewError(ParamError, "invalid param"))
+		return false
+	}
+	namespace := routeNamespace(ctx)
+	if project.Namespace != "" && project.Namespace != namespace {
+		utils.ErrorResponse(ctx, utils.NewError(ParamError,
+			"project of namespace "+project.Namespace+" sent to namespace "+namespace))
+		return false
+	}
+	project.Namespace = namespace
+	if err := project.ValidateName(); err != nil {
+		utils.ErrorResponse(ctx, utils.NewError(ParamError, err))
+		return false
+	}
+	return true
+}
+
+// GetNamespaces returns the namespaces the caller may view a project of,
+// with their quota and usage
+func (hc *HamalControl) GetNamespaces(ctx *gin.Context) {
+	namespaces, err := hc.visibleNamespaces(ctx)
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, utils.NewError(NamespaceError, err))
+		return
+	}
+	utils.Ok(ctx, namespaces)
+}
+
+func (hc *HamalControl) GetNamespace(ctx *gin.Context) {
+	namespaces, err := hc.visibleNamespaces(ctx)
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, utils.NewError(NamespaceError, err))
+		return
+	}
+	for _, namespace := range namespaces {
+		if namespace.Name == ctx.Param("ns") {
+			utils.Ok(ctx, namespace)
+			return
+		}
+	}
+	utils.ErrorResponse(ctx, utils.NewError(NamespaceError, "namespace "+ctx.Param("ns")+" is not exist"))
+}
+
+// visibleNamespaces returns the namespaces whose projects the caller may
+// all view, or one of them
+func (hc *HamalControl) visibleNamespaces(ctx *gin.Context) ([]models.Namespace, error) {
+	namespaces, err := hc.Service.GetNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	projects, err := hc.Service.GetProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]models.Namespace, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		allowed := hc.allowed(ctx, auth.ActionView, namespace.Name, "*", nil)
+		for _, project := range projects {
+			allowed = allowed || project.InNamespace(namespace.Name) &&
+				hc.allowed(ctx, auth.ActionView, namespace.Name, project.Name, project.Labels)
+		}
+		if allowed {
+			visible = append(visible, namespace)
+		}
+	}
+	return visible, nil
+}
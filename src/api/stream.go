@@ -0,0 +1,140 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/auth"
+	"github.com/Dataman-Cloud/hamal/src/service"
+	"github.com/Dataman-Cloud/hamal/src/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// KeepAlive is how often an idle stream sends a ping so proxies keep it open
+const KeepAlive = 15 * time.Second
+
+// StreamWriteWait bounds each write to a stream, a client which stops
+// reading is dropped
+const StreamWriteWait = 10 * time.Second
+
+// Events streams the changes of a project as Server-Sent Events
+func (hc *HamalControl) Events(ctx *gin.Context) {
+	if !hc.authorizeRoute(ctx, auth.ActionView) {
+		return
+	}
+	events, stop, err := hc.Service.Follow(routeProject(ctx))
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, utils.NewError(ProjectNotExist, err))
+		return
+	}
+	defer stop()
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			extendWriteDeadline(ctx)
+			ctx.SSEvent(e.Type, e)
+			return e.Type != service.StreamDeleted
+		case <-time.After(KeepAlive):
+			extendWriteDeadline(ctx)
+			ctx.SSEvent("ping", time.Now().Format(time.RFC3339Nano))
+			return true
+		case <-ctx.Request.Context().Done():
+			// the client went away
+			return false
+		}
+	})
+}
+
+// extendWriteDeadline gives the next write of a stream StreamWriteWait, the
+// WriteTimeout of the server would end the stream since the request was read
+func extendWriteDeadline(ctx *gin.Context) {
+	if err := utils.SetWriteDeadline(ctx.Request, time.Now().Add(StreamWriteWait)); err != nil {
+		log.Debugf("stream write deadline error: %v", err)
+	}
+}
+
+// EventsWebSocket streams the changes of a project over a websocket, one
+// json message per event
+func (hc *HamalControl) EventsWebSocket(ctx *gin.Context) {
+	if !hc.authorizeRoute(ctx, auth.ActionView) {
+		return
+	}
+	events, stop, err := hc.Service.Follow(routeProject(ctx))
+	if err != nil {
+		log.Error(err)
+		utils.ErrorResponse(ctx, utils.NewError(ProjectNotExist, err))
+		return
+	}
+	defer stop()
+
+	upgrader := websocket.Upgrader{
+		// browsers may connect from the origins the cors middleware allowed
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || ctx.Writer.Header().Get("Access-Control-Allow-Origin") != "" {
+				return true
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
+	}
+	// the upgrader answers the failed handshakes
+	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Errorf("project %s websocket upgrade error: %v", routeProject(ctx), err)
+		return
+	}
+	defer func() {
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(StreamWriteWait))
+		ws.Close()
+	}()
+
+	// the client only sends control frames, reading answers its pings and
+	// tells when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			ws.SetWriteDeadline(time.Now().Add(StreamWriteWait))
+			if err := ws.WriteJSON(e); err != nil {
+				log.Errorf("project %s websocket write error: %v", routeProject(ctx), err)
+				return
+			}
+			if e.Type == service.StreamDeleted {
+				return
+			}
+		case <-time.After(KeepAlive):
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(StreamWriteWait)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/service"
+	"github.com/Dataman-Cloud/hamal/src/store"
+	"github.com/Dataman-Cloud/hamal/src/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// writeTimeout is the WriteTimeout of the test server, the streams are held
+// open past it
+const writeTimeout = 200 * time.Millisecond
+
+// newStreamServer serves the event streams of a stored project shop
+func newStreamServer(t *testing.T) (*httptest.Server, *service.HamalService, *models.Project) {
+	gin.SetMode(gin.TestMode)
+	hs := &service.HamalService{Store: store.NewMemoryStore(), PMutex: new(sync.Mutex)}
+	project := &models.Project{
+		Name:          "shop",
+		Notifications: []models.NotifySink{{Type: models.SinkWebhook, URL: "https://example.com/hook?token=abc"}},
+		Applications:  []models.AppUpdateStage{{AppId: "web", State: models.StatePending}},
+	}
+	if err := hs.Store.Put(project); err != nil {
+		t.Fatal(err)
+	}
+
+	hc := &HamalControl{Service: hs}
+	r := gin.New()
+	r.GET("/projects/:name/events", hc.Events)
+	r.GET("/projects/:name/ws", hc.EventsWebSocket)
+	server := httptest.NewUnstartedServer(utils.KeepServerWriter(r))
+	server.Config.WriteTimeout = writeTimeout
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, hs, project
+}
+
+// readEvent returns the type of the next server-sent event
+func readEvent(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		if strings.HasPrefix(line, "event:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		}
+	}
+}
+
+func TestEventsOutliveWriteTimeout(t *testing.T) {
+	server, hs, project := newStreamServer(t)
+
+	resp, err := http.Get(server.URL + "/projects/shop/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if e := readEvent(t, reader); e != service.StreamSnapshot {
+		t.Fatalf("first event %s, want %s", e, service.StreamSnapshot)
+	}
+
+	time.Sleep(3 * writeTimeout)
+	project.Applications[0].State = models.StateUpdating
+	if err := hs.Store.Put(project); err != nil {
+		t.Fatal(err)
+	}
+	if e := readEvent(t, reader); e != service.StreamStage {
+		t.Fatalf("event %s after the write timeout, want %s", e, service.StreamStage)
+	}
+}
+
+func TestWebSocketOutlivesWriteTimeout(t *testing.T) {
+	server, hs, project := newStreamServer(t)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/projects/shop/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	var e service.StreamEvent
+	if err := ws.ReadJSON(&e); err != nil || e.Type != service.StreamSnapshot {
+		t.Fatalf("first event %s (%v), want %s", e.Type, err, service.StreamSnapshot)
+	}
+
+	time.Sleep(3 * writeTimeout)
+	project.Applications[0].State = models.StateUpdating
+	if err := hs.Store.Put(project); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.ReadJSON(&e); err != nil || e.Type != service.StreamStage {
+		t.Fatalf("event %s (%v) after the write timeout, want %s", e.Type, err, service.StreamStage)
+	}
+}
+
+func TestWebSocketSnapshotIsMasked(t *testing.T) {
+	server, _, _ := newStreamServer(t)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/projects/shop/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	var e service.StreamEvent
+	if err := ws.ReadJSON(&e); err != nil || e.Type != service.StreamSnapshot {
+		t.Fatalf("first event %s (%v), want %s", e.Type, err, service.StreamSnapshot)
+	}
+	if url := e.Project.Notifications[0].URL; url != "https://example.com" {
+		t.Errorf("snapshot notifies %q, want the redacted url", url)
+	}
+}
@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+// Outcomes of an audited request
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	// OutcomeDenied is a request refused by the authorization policy
+	OutcomeDenied = "denied"
+)
+
+// SystemIdentity is the identity of the changes hamal makes in swan and of
+// the hooks it calls, whether a caller or the reconciler asked for them
+const (
+	SystemIdentity = "hamal"
+	AuthSystem     = "system"
+)
+
+// Actions of the records of SystemIdentity
+const (
+	ActionSwanUpdate        = "swan-update"
+	ActionSwanProceedUpdate = "swan-proceed-update"
+	ActionSwanCancelUpdate  = "swan-cancel-update"
+	ActionPreHook           = "pre-hook"
+	ActionPostHook          = "post-hook"
+)
+
+// Record is a change requested through the api, or made by hamal in swan or
+// through a hook
+type Record struct {
+	Time string `json:"time"`
+	// Identity is the caller, empty when authentication is disabled
+	Identity string `json:"identity,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+	Remote   string `json:"remote"`
+
+	Action string `json:"action"`
+	// Reason is why hamal made the change, e.g. the trigger of a stage
+	Reason string `json:"reason,omitempty"`
+	Method string `json:"method"`
+	// Path is the path of the api, of swan or the url of the hook
+	Path string `json:"path"`
+	// Namespace of the project, the records written before namespaces
+	// were introduced have none and belong to the default namespace
+	Namespace string `json:"namespace,omitempty"`
+	Project   string `json:"project,omitempty"`
+	AppId     string `json:"app_id,omitempty"`
+	// Stage is the stage approved, or the last stage started once the
+	// request is done
+	Stage *int64 `json:"stage,omitempty"`
+	// PayloadSHA256 is the digest of the request body
+	PayloadSHA256 string `json:"payload_sha256,omitempty"`
+
+	Status  int    `json:"status"`
+	Outcome string `json:"outcome"`
+	// Response is the answer of hamal to an api request, of swan or of the
+	// hook to a change made by hamal
+	Response string `json:"response,omitempty"`
+}
+
+// Filter selects records, the zero values match any
+type Filter struct {
+	Identity string
+	Action   string
+	// Namespace selects the records of a namespace, any when empty
+	Namespace string
+	Project   string
+	AppId     string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+	// Limit keeps the latest records only
+	Limit int
+}
+
+// Match reports whether r is selected by f
+func (f Filter) Match(r *Record) bool {
+	namespace := r.Namespace
+	if namespace == "" {
+		namespace = models.DefaultNamespace
+	}
+	switch {
+	case f.Identity != "" && r.Identity != f.Identity,
+		f.Action != "" && r.Action != f.Action,
+		f.Namespace != "" && namespace != f.Namespace,
+		f.Project != "" && r.Project != f.Project,
+		f.AppId != "" && r.AppId != f.AppId,
+		f.Outcome != "" && r.Outcome != f.Outcome:
+		return false
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339Nano, r.Time)
+		if err != nil || (!f.Since.IsZero() && t.Before(f.Since)) || (!f.Until.IsZero() && !t.Before(f.Until)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Log is the append-only audit log.
+//
+// Implementations must be safe for concurrent use. Records are never
+// changed nor deleted once appended.
+type Log interface {
+	Append(r *Record) error
+	// Query returns the selected records, oldest first
+	Query(f Filter) ([]*Record, error)
+}
+
+// limit keeps the latest records
+func limit(records []*Record, n int) []*Record {
+	if n > 0 && len(records) > n {
+		return records[len(records)-n:]
+	}
+	return records
+}
@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DefaultFilePath is used when no audit log path is configured
+const DefaultFilePath = "hamal-audit.log"
+
+// FileLog appends the records to a file as json lines, every record is
+// synced to disk before Append returns
+type FileLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileLog opens or creates the audit log at path
+func NewFileLog(path string) (*FileLog, error) {
+	if path == "" {
+		path = DefaultFilePath
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLog{path: path, file: f}, nil
+}
+
+// Append writes r at the end of the log
+func (fl *FileLog) Append(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if _, err := fl.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return fl.file.Sync()
+}
+
+// Query scans the log for the selected records
+func (fl *FileLog) Query(filter Filter) ([]*Record, error) {
+	f, err := os.Open(fl.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("audit log %s line %d: %v", fl.path, line, err)
+		}
+		if filter.Match(&r) {
+			records = append(records, &r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return limit(records, filter.Limit), nil
+}
+
+// Close closes the log file
+func (fl *FileLog) Close() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.file.Close()
+}
@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"sync"
+)
+
+// MemoryLog keeps the records in memory, it is meant for tests
+type MemoryLog struct {
+	mu      sync.RWMutex
+	records []Record
+}
+
+// NewMemoryLog returns an empty MemoryLog
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{}
+}
+
+// Append adds a copy of r
+func (ml *MemoryLog) Append(r *Record) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.records = append(ml.records, *r)
+	return nil
+}
+
+// Query returns copies of the selected records
+func (ml *MemoryLog) Query(filter Filter) ([]*Record, error) {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+	var records []*Record
+	for n := range ml.records {
+		if filter.Match(&ml.records[n]) {
+			r := ml.records[n]
+			records = append(records, &r)
+		}
+	}
+	return limit(records, filter.Limit), nil
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// identityKey stores the identity of the caller in the gin context
+const identityKey = "hamal.identity"
+
+// ErrUnknownToken is returned by an authenticator for a token it does not
+// know, the next authenticator is tried
+var ErrUnknownToken = errors.New("unknown token")
+
+// Identity is the authenticated caller of the api
+type Identity struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+	// Method names the authenticator which identified the caller
+	Method string `json:"method"`
+}
+
+// Authenticator identifies the caller presenting a bearer token
+type Authenticator interface {
+	Authenticate(token string) (*Identity, error)
+}
+
+// SetIdentity attaches the identity of the caller to the request
+func SetIdentity(ctx *gin.Context, identity *Identity) {
+	ctx.Set(identityKey, identity)
+}
+
+// GetIdentity returns the identity of the caller, nil when authentication
+// is disabled
+func GetIdentity(ctx *gin.Context) *Identity {
+	v, ok := ctx.Get(identityKey)
+	if !ok {
+		return nil
+	}
+	identity, _ := v.(*Identity)
+	return identity
+}
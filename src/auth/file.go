@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// readChanged reads the file at path when it has been modified since
+// modTime, it returns no data when it has not
+func readChanged(path string, modTime time.Time) ([]byte, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, modTime, err
+	}
+	if info.ModTime().Equal(modTime) {
+		return nil, modTime, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, modTime, err
+	}
+	return data, info.ModTime(), nil
+}
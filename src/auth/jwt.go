@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// MethodJWT names the identities of the JSON Web Tokens
+	MethodJWT = "jwt"
+
+	// DefaultJWKSRefresh is how often the JWKS is loaded again
+	DefaultJWKSRefresh = time.Hour
+	// MinJWKSReload bounds how often a token signed by an unknown key
+	// reloads the JWKS before its refresh
+	MinJWKSReload = time.Minute
+	// JWTLeeway is the clock skew tolerated on exp and nbf
+	JWTLeeway = time.Minute
+	// MaxJWKSSize bounds the JWKS read from a url
+	MaxJWKSSize = 1 << 20
+)
+
+// JWTConfig configures the validation of the JSON Web Tokens issued by an
+// identity provider
+type JWTConfig struct {
+	// JWKS is the path or the http(s) url of the JSON Web Key Set holding
+	// the signing keys
+	JWKS string
+	// Issuer and Audience must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// NameClaim names the caller, sub by default
+	NameClaim string
+	// GroupsClaim lists the groups of the caller, groups by default
+	GroupsClaim string
+	// Refresh is how often the JWKS is loaded again
+	Refresh time.Duration
+}
+
+// JWT authenticates the JSON Web Tokens signed with RS256, RS384, RS512,
+// ES256 or ES384 by a key of the JWKS
+type JWT struct {
+	config JWTConfig
+	client *http.Client
+
+	mu       sync.RWMutex
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+}
+
+// NewJWT loads the JWKS of config
+func NewJWT(config JWTConfig) (*JWT, error) {
+	if config.JWKS == "" {
+		return nil, errors.New("no jwks configured")
+	}
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("jwt issuer and audience are required")
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = "sub"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if config.Refresh <= 0 {
+		config.Refresh = DefaultJWKSRefresh
+	}
+
+	j := &JWT{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Authenticate validates the signature, issuer, audience and expiry of a
+// JSON Web Token, the tokens which are not JWTs are left to the other
+// authenticators
+func (j *JWT) Authenticate(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnknownToken
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrUnknownToken
+	}
+
+	key, err := j.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid jwt signature encoding")
+	}
+	if err := verify(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("invalid jwt claims")
+	}
+	if err := j.validate(claims); err != nil {
+		return nil, err
+	}
+
+	name, _ := claims[j.config.NameClaim].(string)
+	if name == "" {
+		return nil, errors.New("jwt has no " + j.config.NameClaim + " claim")
+	}
+	return &Identity{Name: name, Groups: stringsClaim(claims[j.config.GroupsClaim]), Method: MethodJWT}, nil
+}
+
+// validate checks the registered claims
+func (j *JWT) validate(claims map[string]interface{}) error {
+	if iss, _ := claims["iss"].(string); iss != j.config.Issuer {
+		return errors.New("jwt issued by " + iss)
+	}
+	audience := false
+	for _, aud := range stringsClaim(claims["aud"]) {
+		audience = audience || aud == j.config.Audience
+	}
+	if !audience {
+		return errors.New("jwt is not issued for " + j.config.Audience)
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("jwt has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(JWTLeeway)) {
+		return errors.New("jwt is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(JWTLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("jwt is not valid yet")
+	}
+	return nil
+}
+
+// key returns the signing key kid, the JWKS is loaded again when it is
+// due or when the key is unknown, at most every MinJWKSReload
+func (j *JWT) key(kid string) (crypto.PublicKey, error) {
+	j.mu.RLock()
+	key, ok := j.keys[kid]
+	age := time.Since(j.loadedAt)
+	j.mu.RUnlock()
+
+	if age > j.config.Refresh || (!ok && age > MinJWKSReload) {
+		if err := j.load(); err != nil {
+			// keep the keys loaded last while the provider is down
+			log.Errorf("reload jwks %s error: %v", j.config.JWKS, err)
+		} else {
+			j.mu.RLock()
+			key, ok = j.keys[kid]
+			j.mu.RUnlock()
+		}
+	}
+	if !ok {
+		return nil, errors.New("jwt signed by unknown key " + kid)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// load reads the JWKS and replaces the keys
+func (j *JWT) load() error {
+	data, err := j.readJWKS()
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return errors.New("invalid jwks: " + err.Error())
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warnf("jwks %s key %s skipped: %v", j.config.JWKS, k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("jwks " + j.config.JWKS + " has no signing key")
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.loadedAt = time.Now()
+	j.mu.Unlock()
+	log.Infof("loaded %d signing keys from %s", len(keys), j.config.JWKS)
+	return nil
+}
+
+func (j *JWT) readJWKS() ([]byte, error) {
+	if !strings.HasPrefix(j.config.JWKS, "http://") && !strings.HasPrefix(j.config.JWKS, "https://") {
+		return ioutil.ReadFile(j.config.JWKS)
+	}
+
+	resp, err := j.client.Get(j.config.JWKS)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get jwks %s: %s", j.config.JWKS, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, MaxJWKSSize))
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if e.BitLen() > 31 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid ec point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported key type " + k.Kty)
+}
+
+// verify checks the signature of the signed part of a JWT, the algorithm
+// must match the type of the key
+func verify(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var h hash.Hash
+	var hashId crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h, hashId = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		h, hashId = sha512.New384(), crypto.SHA384
+	case "RS512":
+		h, hashId = sha512.New(), crypto.SHA512
+	default:
+		return errors.New("unsupported jwt algorithm " + alg)
+	}
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(k, hashId, digest, signature); err != nil {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || k.Curve.Params().BitSize != hashId.Size()*8 {
+			break
+		}
+		if len(signature) != 2*size {
+			return errors.New("invalid jwt signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	}
+	return errors.New("jwt algorithm " + alg + " does not match its key")
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// stringsClaim reads a claim holding a string or a list of strings
+func stringsClaim(v interface{}) []string {
+	switch claim := v.(type) {
+	case string:
+		return []string{claim}
+	case []interface{}:
+		var values []string
+		for _, item := range claim {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "hamal"
+)
+
+// testKeys signs the tokens of the tests with an rsa and an ec key
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestJWT(t *testing.T) (*JWT, *testKeys) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, err := json.Marshal(map[string][]jwk{"keys": {
+		{Kty: "RSA", Kid: "rsa", Use: "sig", N: enc(rsaKey.N.Bytes()), E: enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "EC", Kid: "ec", Crv: "P-256", X: enc(ecKey.X.FillBytes(make([]byte, 32))), Y: enc(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{Kty: "RSA", Kid: "enc", Use: "enc", N: enc(rsaKey.N.Bytes()), E: enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := NewJWT(JWTConfig{JWKS: writeFile(t, "jwks.json", string(jwks)), Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j, &testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+// writeFile writes data to the file name of a temporary directory and
+// returns its path
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sign returns a token of the claims signed with the key kid
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch kid {
+	case "ec":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    testIssuer,
+		"aud":    []string{"other", testAudience},
+		"sub":    "alice",
+		"groups": []string{"ops", "payments"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	j, keys := newTestJWT(t)
+
+	for _, tc := range []struct{ alg, kid string }{{"RS256", "rsa"}, {"ES256", "ec"}} {
+		identity, err := j.Authenticate(keys.sign(t, tc.alg, tc.kid, validClaims()))
+		if err != nil {
+			t.Fatalf("%s token refused: %v", tc.alg, err)
+		}
+		if identity.Name != "alice" || identity.Method != MethodJWT ||
+			strings.Join(identity.Groups, ",") != "ops,payments" {
+			t.Errorf("%s token identified as %+v", tc.alg, identity)
+		}
+	}
+}
+
+func TestJWTRefused(t *testing.T) {
+	j, keys := newTestJWT(t)
+
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := validClaims()
+		change(c)
+		return c
+	}
+	for name, token := range map[string]string{
+		"expired": keys.sign(t, "RS256", "rsa", claims(func(c map[string]interface{}) {
+			c["exp"] = time.Now().Add(-JWTLeeway - time.Minute).Unix()
+		})),
+		"no expiry": keys.sign(t, "RS256", "rsa", claims(func(c map[string]interface{}) { delete(c, "exp") })),
+		"not valid yet": keys.sign(t, "RS256", "rsa", claims(func(c map[string]interface{}) {
+			c["nbf"] = time.Now().Add(JWTLeeway + time.Hour).Unix()
+		})),
+		"other issuer":            keys.sign(t, "RS256", "rsa", claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })),
+		"other audience":          keys.sign(t, "RS256", "rsa", claims(func(c map[string]interface{}) { c["aud"] = "other" })),
+		"no subject":              keys.sign(t, "RS256", "rsa", claims(func(c map[string]interface{}) { delete(c, "sub") })),
+		"unknown key":             keys.sign(t, "RS256", "other", validClaims()),
+		"encryption key":          keys.sign(t, "RS256", "enc", validClaims()),
+		"alg of another key type": keys.sign(t, "ES256", "rsa", validClaims()),
+		"alg none":                strings.Join(strings.Split(keys.sign(t, "none", "rsa", validClaims()), ".")[:2], ".") + ".",
+		"tampered claims": func() string {
+			parts := strings.Split(keys.sign(t, "RS256", "rsa", validClaims()), ".")
+			payload, _ := json.Marshal(claims(func(c map[string]interface{}) { c["sub"] = "mallory" }))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}(),
+	} {
+		if identity, err := j.Authenticate(token); err == nil {
+			t.Errorf("%s token accepted as %+v", name, identity)
+		}
+	}
+
+	// the static tokens are left to the other authenticators
+	if _, err := j.Authenticate("9f86d081884c7d659a2feaa0c55ad015"); err != ErrUnknownToken {
+		t.Errorf("static token: %v, want ErrUnknownToken", err)
+	}
+}
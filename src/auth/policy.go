@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Actions authorized on a project
+const (
+	// ActionView reads the project, its apps and its events
+	ActionView = "view"
+	// ActionDeploy creates and updates the project and drives its rollout:
+	// advance, roll back, revert, pause and resume
+	ActionDeploy = "deploy"
+	// ActionApprove approves the stages waiting for an approval
+	ActionApprove = "approve"
+	// ActionAdmin is every action, and the ones which are only for admins
+	ActionAdmin = "admin"
+)
+
+// Roles granted by the policy
+const (
+	RoleViewer   = "viewer"
+	RoleDeployer = "deployer"
+	RoleApprover = "approver"
+	RoleAdmin    = "admin"
+)
+
+var roleActions = map[string][]string{
+	RoleViewer:   {ActionView},
+	RoleDeployer: {ActionView, ActionDeploy},
+	RoleApprover: {ActionView, ActionApprove},
+	RoleAdmin:    {ActionView, ActionDeploy, ActionApprove, ActionAdmin},
+}
+
+// Binding grants a role to subjects on the projects of its namespaces which
+// it names and which carry its labels
+type Binding struct {
+	// Subjects are user:<name>, group:<name> or * for every authenticated
+	// caller
+	Subjects []string `json:"subjects"`
+	Role     string   `json:"role"`
+	// Namespaces names the namespaces of the projects, * for all, the
+	// default namespace when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Projects names the projects, * for all, any project when empty
+	Projects []string `json:"projects,omitempty"`
+	// Labels selects the projects carrying all of them
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Policy authorizes the actions of the callers with the bindings of a
+// policy file, the file is read again when it changes so the policy is
+// reloaded without a restart
+type Policy struct {
+	path string
+
+	mu       sync.RWMutex
+	modTime  time.Time
+	bindings []Binding
+}
+
+// NewPolicy loads the policy file at path
+func NewPolicy(path string) (*Policy, error) {
+	p := &Policy{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Allowed reports whether identity may do action on the project of the
+// namespace with the given name and labels, * stands for every namespace or
+// every project
+func (p *Policy) Allowed(identity *Identity, action, namespace, project string, labels map[string]string) bool {
+	if err := p.reload(); err != nil {
+		// keep the policy loaded last rather than lock everyone out
+		log.Errorf("reload policy file %s error: %v", p.path, err)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, b := range p.bindings {
+		if b.grants(action) && b.matchSubject(identity) &&
+			b.matchNamespace(namespace) && b.matchProject(project, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b Binding) grants(action string) bool {
+	for _, a := range roleActions[b.Role] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (b Binding) matchSubject(identity *Identity) bool {
+	for _, s := range b.Subjects {
+		switch {
+		case s == "*":
+			return true
+		case s == "user:"+identity.Name:
+			return true
+		case strings.HasPrefix(s, "group:"):
+			for _, g := range identity.Groups {
+				if s == "group:"+g {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (b Binding) matchNamespace(namespace string) bool {
+	if len(b.Namespaces) == 0 {
+		return namespace == "" || namespace == models.DefaultNamespace
+	}
+	for _, name := range b.Namespaces {
+		if name == "*" || name == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (b Binding) matchProject(project string, labels map[string]string) bool {
+	if len(b.Projects) > 0 {
+		named := false
+		for _, name := range b.Projects {
+			named = named || name == "*" || name == project
+		}
+		if !named {
+			return false
+		}
+	}
+	for k, v := range b.Labels {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// reload reads the policy file when it changed since it was read
+func (p *Policy) reload() error {
+	p.mu.RLock()
+	modTime := p.modTime
+	p.mu.RUnlock()
+	data, modTime, err := readChanged(p.path, modTime)
+	if err != nil || data == nil {
+		return err
+	}
+
+	var policy struct {
+		Bindings []Binding `json:"bindings"`
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return errors.New("invalid policy file: " + err.Error())
+	}
+	for n, b := range policy.Bindings {
+		if _, ok := roleActions[b.Role]; !ok {
+			return fmt.Errorf("binding %d: unknown role %s", n, b.Role)
+		}
+		if len(b.Subjects) == 0 {
+			return fmt.Errorf("binding %d: no subject", n)
+		}
+		for _, s := range b.Subjects {
+			if s != "*" && !strings.HasPrefix(s, "user:") && !strings.HasPrefix(s, "group:") {
+				return fmt.Errorf("binding %d: invalid subject %s", n, s)
+			}
+		}
+		for _, namespace := range b.Namespaces {
+			if namespace == "*" {
+				continue
+			}
+			if err := models.ValidateNamespace(namespace); err != nil {
+				return fmt.Errorf("binding %d: %v", n, err)
+			}
+		}
+		if len(b.Projects) == 0 && len(b.Labels) == 0 {
+			return fmt.Errorf("binding %d: no project nor label, use projects [\"*\"] for every project", n)
+		}
+	}
+
+	p.mu.Lock()
+	p.bindings = policy.Bindings
+	p.modTime = modTime
+	p.mu.Unlock()
+	log.Infof("loaded %d bindings from %s", len(policy.Bindings), p.path)
+	return nil
+}
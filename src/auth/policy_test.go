@@ -0,0 +1,68 @@
+package auth
+
+import "testing"
+
+const testPolicy = `{
+    "bindings": [
+        {"subjects": ["group:ops"], "role": "admin", "namespaces": ["*"], "projects": ["*"]},
+        {"subjects": ["group:payments"], "role": "deployer", "namespaces": ["payments"], "projects": ["*"]},
+        {"subjects": ["group:payments"], "role": "deployer", "labels": {"team": "payments"}},
+        {"subjects": ["user:alice"], "role": "approver", "projects": ["nginx01"]},
+        {"subjects": ["*"], "role": "viewer", "projects": ["*"]}
+    ]
+}`
+
+func TestPolicyAllowed(t *testing.T) {
+	policy, err := NewPolicy(writeFile(t, "policy.json", testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := &Identity{Name: "bob", Groups: []string{"ops"}}
+	payments := &Identity{Name: "carol", Groups: []string{"payments"}}
+	alice := &Identity{Name: "alice"}
+	team := map[string]string{"team": "payments"}
+
+	for _, tc := range []struct {
+		identity  *Identity
+		action    string
+		namespace string
+		project   string
+		labels    map[string]string
+		want      bool
+	}{
+		{ops, ActionAdmin, "payments", "shop", nil, true},
+		{ops, ActionAdmin, "*", "*", nil, true},
+		{payments, ActionDeploy, "payments", "shop", nil, true},
+		{payments, ActionApprove, "payments", "shop", nil, false},
+		{payments, ActionDeploy, "", "shop", nil, false},
+		{payments, ActionDeploy, "", "shop", team, true},
+		{payments, ActionDeploy, "", "shop", map[string]string{"team": "web"}, false},
+		{alice, ActionApprove, "", "nginx01", nil, true},
+		{alice, ActionApprove, "", "nginx02", nil, false},
+		{alice, ActionApprove, "payments", "nginx01", nil, false},
+		{alice, ActionView, "", "nginx02", nil, true},
+		{alice, ActionView, "payments", "shop", nil, false},
+		{alice, ActionDeploy, "", "nginx01", nil, false},
+	} {
+		if got := policy.Allowed(tc.identity, tc.action, tc.namespace, tc.project, tc.labels); got != tc.want {
+			t.Errorf("%s %v %s on %s/%s %v: allowed %v, want %v",
+				tc.identity.Name, tc.identity.Groups, tc.action, tc.namespace, tc.project, tc.labels, got, tc.want)
+		}
+	}
+}
+
+func TestPolicyInvalid(t *testing.T) {
+	for _, policy := range []string{
+		`{"bindings": [{"subjects": ["*"], "role": "root", "projects": ["*"]}]}`,
+		`{"bindings": [{"role": "viewer", "projects": ["*"]}]}`,
+		`{"bindings": [{"subjects": ["team:ops"], "role": "viewer", "projects": ["*"]}]}`,
+		`{"bindings": [{"subjects": ["*"], "role": "viewer", "namespaces": ["Pay Ments"], "projects": ["*"]}]}`,
+		`{"bindings": [{"subjects": ["*"], "role": "viewer"}]}`,
+		`{"bindings": `,
+	} {
+		if _, err := NewPolicy(writeFile(t, "policy.json", policy)); err == nil {
+			t.Errorf("policy %s loaded", policy)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// MethodToken names the identities of the credentials file
+const MethodToken = "token"
+
+// Credential is an entry of the credentials file, only the sha256 of the
+// token is stored, e.g. the output of `echo -n $TOKEN | sha256sum`
+type Credential struct {
+	Name        string   `json:"name"`
+	TokenSHA256 string   `json:"token_sha256"`
+	Groups      []string `json:"groups,omitempty"`
+}
+
+// TokenFile authenticates the static tokens of a credentials file, the file
+// is read again when it changes so tokens are rotated without a restart
+type TokenFile struct {
+	path string
+
+	mu          sync.RWMutex
+	modTime     time.Time
+	credentials []Credential
+}
+
+// NewTokenFile loads the credentials file at path
+func NewTokenFile(path string) (*TokenFile, error) {
+	tf := &TokenFile{path: path}
+	if err := tf.reload(); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// Authenticate returns the identity whose token hashes as token does
+func (tf *TokenFile) Authenticate(token string) (*Identity, error) {
+	if err := tf.reload(); err != nil {
+		// keep the credentials loaded last rather than lock everyone out
+		log.Errorf("reload credentials file %s error: %v", tf.path, err)
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	tf.mu.RLock()
+	defer tf.mu.RUnlock()
+	for _, c := range tf.credentials {
+		stored, err := hex.DecodeString(c.TokenSHA256)
+		if err != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare(stored, sum[:]) == 1 {
+			return &Identity{Name: c.Name, Groups: c.Groups, Method: MethodToken}, nil
+		}
+	}
+	return nil, ErrUnknownToken
+}
+
+// reload reads the credentials file when it changed since it was read
+func (tf *TokenFile) reload() error {
+	tf.mu.RLock()
+	modTime := tf.modTime
+	tf.mu.RUnlock()
+	data, modTime, err := readChanged(tf.path, modTime)
+	if err != nil || data == nil {
+		return err
+	}
+
+	var credentials []Credential
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return errors.New("invalid credentials file: " + err.Error())
+	}
+	names := make(map[string]bool)
+	for n, c := range credentials {
+		if c.Name == "" {
+			return fmt.Errorf("credential %d has no name", n)
+		}
+		if names[c.Name] {
+			return errors.New("credential " + c.Name + " is defined twice")
+		}
+		names[c.Name] = true
+		if sum, err := hex.DecodeString(c.TokenSHA256); err != nil || len(sum) != sha256.Size {
+			return errors.New("credential " + c.Name + ": token_sha256 is not a sha256 hex digest")
+		}
+	}
+
+	tf.mu.Lock()
+	tf.credentials = credentials
+	tf.modTime = modTime
+	tf.mu.Unlock()
+	log.Infof("loaded %d credentials from %s", len(credentials), tf.path)
+	return nil
+}
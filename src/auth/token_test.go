@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCredentials writes a credentials file giving token to the deployer
+func writeCredentials(t *testing.T, path, token string) {
+	t.Helper()
+	sum := sha256.Sum256([]byte(token))
+	data := fmt.Sprintf(`[{"name": "deployer", "token_sha256": %q, "groups": ["ops"]}]`, hex.EncodeToString(sum[:]))
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	writeCredentials(t, path, "s3cr3t")
+	tf, err := NewTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := tf.Authenticate("s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "deployer" || identity.Method != MethodToken || len(identity.Groups) != 1 {
+		t.Errorf("token authenticated as %+v", identity)
+	}
+	if _, err := tf.Authenticate("guess"); err != ErrUnknownToken {
+		t.Errorf("unknown token: %v", err)
+	}
+
+	// a rotated token is picked up without a restart
+	writeCredentials(t, path, "rotated")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tf.Authenticate("rotated"); err != nil {
+		t.Errorf("rotated token: %v", err)
+	}
+	if _, err := tf.Authenticate("s3cr3t"); err != ErrUnknownToken {
+		t.Errorf("revoked token: %v", err)
+	}
+}
+
+func TestSampleCredentialsRefused(t *testing.T) {
+	// the sample holds a placeholder which must be replaced before use
+	if _, err := NewTokenFile("../../credentials.json.simple"); err == nil {
+		t.Error("sample credentials file loaded")
+	}
+}
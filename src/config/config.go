@@ -8,12 +8,62 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Config defines the conf info
 type Config struct {
-	Addr     string `require:"true" alias:"ADDR"`
-	SwanAddr string `require:"true" alias:"SWAN_ADDR"`
+	Addr      string `require:"true" alias:"ADDR"`
+	SwanAddr  string `require:"false" alias:"SWAN_ADDR"`
+	StorePath string `require:"false" alias:"STORE_PATH"`
+	// SwanClusters names the swan clusters by their cluster id, e.g.
+	// "dc1=http://swan1:9999,dc2=http://swan2:9999", SwanAddr is the
+	// cluster of the apps which name none
+	SwanClusters string `require:"false" alias:"SWAN_CLUSTERS"`
+
+	// SwanTimeout bounds every call to swan but its event stream
+	SwanTimeout time.Duration `require:"false" alias:"SWAN_TIMEOUT"`
+
+	ReconcileInterval time.Duration `require:"false" alias:"RECONCILE_INTERVAL"`
+	// SwanPollInterval replaces ReconcileInterval while the swan event
+	// stream is connected
+	SwanPollInterval  time.Duration `require:"false" alias:"SWAN_POLL_INTERVAL"`
+	HealthGracePeriod time.Duration `require:"false" alias:"HEALTH_GRACE_PERIOD"`
+
+	// rollout events of every project are sent to these sinks, NOTIFY_EMAIL
+	// is a comma separated list of addresses
+	NotifyWebhook string `require:"false" alias:"NOTIFY_WEBHOOK"`
+	NotifySlack   string `require:"false" alias:"NOTIFY_SLACK"`
+	NotifyEmail   string `require:"false" alias:"NOTIFY_EMAIL"`
+	SMTPAddr      string `require:"false" alias:"SMTP_ADDR"`
+	SMTPFrom      string `require:"false" alias:"SMTP_FROM"`
+	SMTPUsername  string `require:"false" alias:"SMTP_USERNAME"`
+	SMTPPassword  string `require:"false" alias:"SMTP_PASSWORD"`
+
+	// CredentialsFile holds the api tokens, hashed, the api is open to
+	// anyone when it is not set
+	CredentialsFile string `require:"false" alias:"CREDENTIALS_FILE"`
+	// JWTJWKS is the path or url of the signing keys of the JSON Web Tokens
+	// accepted on top of the credentials file, JWTIssuer and JWTAudience
+	// must match their iss and aud
+	JWTJWKS        string        `require:"false" alias:"JWT_JWKS"`
+	JWTIssuer      string        `require:"false" alias:"JWT_ISSUER"`
+	JWTAudience    string        `require:"false" alias:"JWT_AUDIENCE"`
+	JWTNameClaim   string        `require:"false" alias:"JWT_NAME_CLAIM"`
+	JWTGroupsClaim string        `require:"false" alias:"JWT_GROUPS_CLAIM"`
+	JWTJWKSRefresh time.Duration `require:"false" alias:"JWT_JWKS_REFRESH"`
+	// PolicyFile grants roles on the projects to the authenticated callers,
+	// they may do everything when it is not set
+	PolicyFile string `require:"false" alias:"POLICY_FILE"`
+	// NamespacesFile declares the namespaces and their quotas, any
+	// namespace may be used without quota when it is not set
+	NamespacesFile string `require:"false" alias:"NAMESPACES_FILE"`
+	// AuditLog is the path of the append-only log of the changes made
+	// through the api
+	AuditLog string `require:"false" alias:"AUDIT_LOG"`
+	// CORSOrigins are the origins allowed to call the api from a browser,
+	// comma separated, "*" by default
+	CORSOrigins string `require:"false" alias:"CORS_ORIGINS"`
 }
 
 var c *Config
@@ -71,6 +121,12 @@ func LoadConfig() {
 			} else if rb {
 				log.Fatalf("config %s value invalid", robj.Type().Field(i).Tag.Get("alias"))
 			}
+		case "time.Duration":
+			if d, err := time.ParseDuration(os.Getenv(robj.Type().Field(i).Tag.Get("alias"))); err == nil {
+				robj.Field(i).Set(reflect.ValueOf(d))
+			} else if rb {
+				log.Fatalf("config %s value invalid", robj.Type().Field(i).Tag.Get("alias"))
+			}
 		}
 	}
 }
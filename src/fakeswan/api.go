@@ -0,0 +1,213 @@
+package fakeswan
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/src/types"
+	"github.com/gin-gonic/gin"
+)
+
+// KeepAlive is how often an idle event stream sends a comment
+const KeepAlive = 15 * time.Second
+
+func (s *Server) router() http.Handler {
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	apps := r.Group("/v_beta/apps/:app_id", s.injectFaults)
+	{
+		apps.GET("", s.getApp)
+		apps.PUT("", s.updateApp)
+		apps.GET("/versions", s.listVersions)
+		apps.GET("/versions/:version_id", s.getVersion)
+		apps.PATCH("/proceed-update", s.proceedUpdate)
+		apps.PATCH("/cancel-update", s.cancelUpdate)
+		apps.PATCH("/scale", s.scaleApp)
+	}
+	r.GET("/events", s.events)
+
+	// scripting of the fake, not part of the swan api
+	fake := r.Group("/fake")
+	{
+		fake.GET("/apps", s.listApps)
+		fake.POST("/apps", s.addApp)
+		fake.GET("/apps/:app_id/faults", s.getFaults)
+		fake.PUT("/apps/:app_id/faults", s.setFaults)
+	}
+	return r
+}
+
+// injectFaults delays and fails the calls on an app as scripted
+func (s *Server) injectFaults(ctx *gin.Context) {
+	status, latency := s.inject(ctx.Param("app_id"), ctx.Request.Method != "GET")
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if status != 0 {
+		ctx.JSON(status, gin.H{"message": "failure injected by fake swan"})
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+func (s *Server) getApp(ctx *gin.Context) {
+	app, err := s.App(ctx.Param("app_id"))
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, app)
+}
+
+func (s *Server) updateApp(ctx *gin.Context) {
+	var version types.Version
+	if err := ctx.BindJSON(&version); err != nil {
+		return
+	}
+	if err := s.UpdateApp(ctx.Param("app_id"), version); err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "app updating"})
+}
+
+func (s *Server) listVersions(ctx *gin.Context) {
+	versions, err := s.Versions(ctx.Param("app_id"))
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, versions)
+}
+
+func (s *Server) getVersion(ctx *gin.Context) {
+	version, err := s.Version(ctx.Param("app_id"), ctx.Param("version_id"))
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, version)
+}
+
+type instancesParam struct {
+	Instances int `json:"instances"`
+}
+
+func (s *Server) proceedUpdate(ctx *gin.Context) {
+	var param instancesParam
+	if err := ctx.BindJSON(&param); err != nil {
+		return
+	}
+	if err := s.ProceedUpdate(ctx.Param("app_id"), param.Instances); err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "update proceeded"})
+}
+
+func (s *Server) cancelUpdate(ctx *gin.Context) {
+	if err := s.CancelUpdate(ctx.Param("app_id")); err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "update cancelled"})
+}
+
+func (s *Server) scaleApp(ctx *gin.Context) {
+	var param instancesParam
+	if err := ctx.BindJSON(&param); err != nil {
+		return
+	}
+	if err := s.ScaleApp(ctx.Param("app_id"), param.Instances); err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "app scaling"})
+}
+
+// events streams the task and app events as swan does
+func (s *Server) events(ctx *gin.Context) {
+	events, stop := s.subscribe()
+	defer stop()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Writer.WriteHeaderNow()
+	ctx.Writer.Flush()
+
+	closed := ctx.Writer.CloseNotify()
+	for {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			if _, err := ctx.Writer.Write(msg); err != nil {
+				return
+			}
+		case <-time.After(KeepAlive):
+			if _, err := ctx.Writer.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+func (s *Server) listApps(ctx *gin.Context) {
+	ids := s.Apps()
+	sort.Strings(ids)
+	ctx.JSON(http.StatusOK, ids)
+}
+
+// addApp creates an app running the posted version, the app_id query
+// overrides the id swan would give
+func (s *Server) addApp(ctx *gin.Context) {
+	var version types.Version
+	if err := ctx.BindJSON(&version); err != nil {
+		return
+	}
+	app, err := s.AddApp(ctx.Query("app_id"), version)
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, app)
+}
+
+func (s *Server) getFaults(ctx *gin.Context) {
+	faults, err := s.Faults(ctx.Param("app_id"))
+	if err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, faults)
+}
+
+func (s *Server) setFaults(ctx *gin.Context) {
+	var faults Faults
+	if err := ctx.BindJSON(&faults); err != nil {
+		return
+	}
+	if err := s.SetFaults(ctx.Param("app_id"), faults); err != nil {
+		errorResponse(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, faults)
+}
+
+func errorResponse(ctx *gin.Context, err error) {
+	status := http.StatusBadRequest
+	switch err {
+	case errAppNotExist, errVersionNotExist:
+		status = http.StatusNotFound
+	case errAppExist:
+		status = http.StatusConflict
+	}
+	ctx.JSON(status, gin.H{"message": err.Error()})
+}
@@ -0,0 +1,579 @@
+package fakeswan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// States of the fake apps and tasks, as named by swan
+const (
+	AppNormal   = "normal"
+	AppUpdating = "updating"
+
+	TaskStaging = "TASK_STAGING"
+	TaskRunning = "TASK_RUNNING"
+	TaskFailed  = "TASK_FAILED"
+	TaskKilled  = "TASK_KILLED"
+
+	// DefaultFirstStep is how many instances a new version updates before
+	// the update is proceeded
+	DefaultFirstStep = 1
+)
+
+var (
+	errAppNotExist     = errors.New("app not exist")
+	errVersionNotExist = errors.New("version not exist")
+	errAppExist        = errors.New("app already exists")
+)
+
+// Faults scripts the misbehaviour of a fake app
+type Faults struct {
+	// Latency delays every call on the app
+	Latency time.Duration `json:"latency"`
+	// FailCalls fails the next calls on the app with FailStatus
+	FailCalls int `json:"fail_calls"`
+	// FailUpdates fails the next update, proceed, cancel and scale calls
+	// with FailStatus
+	FailUpdates int `json:"fail_updates"`
+	// FailStatus is answered to the failed calls, 503 by default
+	FailStatus int `json:"fail_status"`
+	// FailTasks makes the next tasks moved to a new version fail
+	FailTasks int `json:"fail_tasks"`
+	// UnhealthyTasks makes the next tasks moved to a new version run
+	// unhealthy
+	UnhealthyTasks int `json:"unhealthy_tasks"`
+}
+
+// UnmarshalJSON accepts the latency as a duration string, e.g. "200ms"
+func (f *Faults) UnmarshalJSON(data []byte) error {
+	type faults Faults
+	var raw struct {
+		faults
+		Latency interface{} `json:"latency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*f = Faults(raw.faults)
+	switch latency := raw.Latency.(type) {
+	case nil:
+		f.Latency = 0
+	case string:
+		d, err := time.ParseDuration(latency)
+		if err != nil {
+			return fmt.Errorf("invalid latency %s", latency)
+		}
+		f.Latency = d
+	case float64:
+		f.Latency = time.Duration(latency)
+	default:
+		return errors.New("invalid latency")
+	}
+	return nil
+}
+
+type app struct {
+	app      types.App
+	versions map[string]*types.Version
+	faults   Faults
+	// starts tells how the staging tasks end, by task id
+	starts map[string]start
+}
+
+// start is how a staging task ends
+type start struct {
+	fail      bool
+	unhealthy bool
+}
+
+// Server is an in-memory swan cluster serving the app api hamal uses.
+//
+// A new version updates FirstStep instances, proceed-update moves more tasks
+// to it and swan makes it the current version once every task runs it.
+// Tasks start in TASK_STAGING and run after StartDelay.
+type Server struct {
+	mu      sync.Mutex
+	cluster string
+	apps    map[string]*app
+	nextId  int
+	subs    map[int]chan []byte
+	handler http.Handler
+
+	// FirstStep is how many instances a new version updates
+	FirstStep int
+	// StartDelay is how long a task stages before it runs
+	StartDelay time.Duration
+}
+
+// New returns an empty fake swan cluster with the given id
+func New(cluster string) *Server {
+	s := &Server{
+		cluster:   cluster,
+		apps:      make(map[string]*app),
+		subs:      make(map[int]chan []byte),
+		FirstStep: DefaultFirstStep,
+	}
+	s.handler = s.router()
+	return s
+}
+
+// ServeHTTP serves the swan api
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// AppId returns the id swan gives to the app of version, e.g.
+// nginx01-zdou-datamanmesos
+func (s *Server) AppId(version types.Version) string {
+	return version.AppID + "-" + version.RunAs + "-" + s.cluster
+}
+
+// AddApp creates an app running version, its id is the given one or the
+// one swan would give when it is empty
+func (s *Server) AddApp(appId string, version types.Version) (types.App, error) {
+	if appId == "" {
+		appId = s.AppId(version)
+	}
+	if version.Instances <= 0 {
+		return types.App{}, errors.New("app " + appId + " has no instance")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.apps[appId]; ok {
+		return types.App{}, errAppExist
+	}
+
+	now := time.Now()
+	a := &app{
+		app: types.App{
+			ID:        appId,
+			Name:      version.AppID,
+			Instances: int(version.Instances),
+			RunAs:     version.RunAs,
+			ClusterID: s.cluster,
+			Mode:      version.Mode,
+			State:     AppNormal,
+			Created:   now,
+			Updated:   now,
+		},
+		versions: make(map[string]*types.Version),
+		starts:   make(map[string]start),
+	}
+	current := s.addVersion(a, version)
+	a.app.CurrentVersion = current
+	for n := 0; n < a.app.Instances; n++ {
+		a.app.Tasks = append(a.app.Tasks, s.newTask(a, current, n, false, false))
+	}
+	s.apps[appId] = a
+	for _, task := range a.app.Tasks {
+		s.startTask(appId, task)
+	}
+	return s.snapshot(a), nil
+}
+
+// SetFaults replaces the faults scripted for an app
+func (s *Server) SetFaults(appId string, faults Faults) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return errAppNotExist
+	}
+	a.faults = faults
+	return nil
+}
+
+// Faults returns the faults left to inject into an app
+func (s *Server) Faults(appId string) (Faults, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return Faults{}, errAppNotExist
+	}
+	return a.faults, nil
+}
+
+// App returns a copy of an app with its tasks
+func (s *Server) App(appId string) (types.App, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return types.App{}, errAppNotExist
+	}
+	return s.snapshot(a), nil
+}
+
+// Apps returns the ids of the apps
+func (s *Server) Apps() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.apps))
+	for id := range s.apps {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Versions returns the versions of an app, oldest first
+func (s *Server) Versions(appId string) ([]types.Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return nil, errAppNotExist
+	}
+	versions := make([]types.Version, 0, len(a.app.Versions))
+	for _, id := range a.app.Versions {
+		versions = append(versions, *a.versions[id])
+	}
+	return versions, nil
+}
+
+// Version returns a version of an app
+func (s *Server) Version(appId, versionId string) (types.Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return types.Version{}, errAppNotExist
+	}
+	version, ok := a.versions[versionId]
+	if !ok {
+		return types.Version{}, errVersionNotExist
+	}
+	return *version, nil
+}
+
+// UpdateApp submits a new version of an app and updates its first
+// FirstStep instances
+func (s *Server) UpdateApp(appId string, version types.Version) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return errAppNotExist
+	}
+	if a.app.State != AppNormal || a.app.ProposedVersion != nil {
+		return errors.New("app " + appId + " is being updated")
+	}
+
+	version.Instances = int32(a.app.Instances)
+	a.app.ProposedVersion = s.addVersion(a, version)
+	a.app.State = AppUpdating
+	s.moveTasks(a, a.app.ProposedVersion, s.FirstStep)
+	s.appChanged(a)
+	return nil
+}
+
+// ProceedUpdate moves the given number of instances more to the proposed
+// version of an app
+func (s *Server) ProceedUpdate(appId string, instances int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return errAppNotExist
+	}
+	if a.app.ProposedVersion == nil {
+		return errors.New("app " + appId + " is not being updated")
+	}
+	if instances <= 0 {
+		return errors.New("invalid instances")
+	}
+	left := 0
+	for _, task := range a.app.Tasks {
+		if task.VersionID != a.app.ProposedVersion.ID {
+			left++
+		}
+	}
+	if instances > left {
+		return fmt.Errorf("only %d instances left to update", left)
+	}
+
+	s.moveTasks(a, a.app.ProposedVersion, instances)
+	s.appChanged(a)
+	return nil
+}
+
+// CancelUpdate moves the updated tasks of an app back to its current
+// version and drops the proposed one
+func (s *Server) CancelUpdate(appId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return errAppNotExist
+	}
+	if a.app.ProposedVersion == nil {
+		return errors.New("app " + appId + " is not being updated")
+	}
+
+	proposed := a.app.ProposedVersion.ID
+	a.app.ProposedVersion = nil
+	for n, task := range a.app.Tasks {
+		if task.VersionID == proposed {
+			s.replaceTask(a, n, a.app.CurrentVersion, false, false)
+		}
+	}
+	a.app.State = AppNormal
+	s.appChanged(a)
+	return nil
+}
+
+// ScaleApp changes the number of instances of an app which is not being
+// updated
+func (s *Server) ScaleApp(appId string, instances int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return errAppNotExist
+	}
+	if a.app.State != AppNormal {
+		return errors.New("app " + appId + " is being updated")
+	}
+	if instances <= 0 {
+		return errors.New("invalid instances")
+	}
+
+	for _, task := range a.app.Tasks[min(instances, len(a.app.Tasks)):] {
+		delete(a.starts, task.ID)
+		s.publish("task_rm", types.TaskInfoEvent{TaskId: task.ID, AppId: a.app.ID, State: TaskKilled, ClusterId: s.cluster})
+	}
+	if instances < len(a.app.Tasks) {
+		a.app.Tasks = a.app.Tasks[:instances]
+	}
+	for n := len(a.app.Tasks); n < instances; n++ {
+		task := s.newTask(a, a.app.CurrentVersion, n, false, false)
+		a.app.Tasks = append(a.app.Tasks, task)
+		s.startTask(a.app.ID, task)
+	}
+	a.app.Instances = instances
+	a.app.CurrentVersion.Instances = int32(instances)
+	s.appChanged(a)
+	return nil
+}
+
+func (s *Server) addVersion(a *app, version types.Version) *types.Version {
+	s.nextId++
+	v := version
+	v.ID = fmt.Sprintf("%d", time.Now().UnixNano()+int64(s.nextId))
+	v.AppID = a.app.Name
+	if a.app.CurrentVersion != nil {
+		v.PreviousVersionID = a.app.CurrentVersion.ID
+	}
+	a.versions[v.ID] = &v
+	a.app.Versions = append(a.app.Versions, v.ID)
+	return &v
+}
+
+// moveTasks replaces up to count tasks which do not run version yet, the
+// scripted faults are spent on them
+func (s *Server) moveTasks(a *app, version *types.Version, count int) {
+	for n, task := range a.app.Tasks {
+		if count <= 0 {
+			return
+		}
+		if task.VersionID == version.ID {
+			continue
+		}
+		fail := a.faults.FailTasks > 0
+		if fail {
+			a.faults.FailTasks--
+		}
+		unhealthy := !fail && a.faults.UnhealthyTasks > 0
+		if unhealthy {
+			a.faults.UnhealthyTasks--
+		}
+		s.replaceTask(a, n, version, fail, unhealthy)
+		count--
+	}
+}
+
+// replaceTask kills the n-th task of app and stages a new one running
+// version in its slot
+func (s *Server) replaceTask(a *app, n int, version *types.Version, fail, unhealthy bool) {
+	old := a.app.Tasks[n]
+	delete(a.starts, old.ID)
+	s.publish("task_rm", types.TaskInfoEvent{TaskId: old.ID, AppId: a.app.ID, State: TaskKilled, ClusterId: s.cluster})
+	task := s.newTask(a, version, n, fail, unhealthy)
+	a.app.Tasks[n] = task
+	s.startTask(a.app.ID, task)
+}
+
+// newTask returns a staging task of version in the n-th slot of app
+func (s *Server) newTask(a *app, version *types.Version, n int, fail, unhealthy bool) *types.Task {
+	s.nextId++
+	id := fmt.Sprintf("%d-%s-%d", n, a.app.ID, s.nextId)
+	a.starts[id] = start{fail: fail, unhealthy: unhealthy}
+	return &types.Task{
+		ID:        id,
+		AppID:     a.app.ID,
+		VersionID: version.ID,
+		Status:    TaskStaging,
+		CurrentTask: &types.TaskHistory{
+			ID:        id,
+			AppID:     a.app.ID,
+			VersionID: version.ID,
+			State:     TaskStaging,
+		},
+		IP:      fmt.Sprintf("10.0.0.%d", n+1),
+		Created: time.Now(),
+		Healthy: false,
+	}
+}
+
+// startTask runs or fails a staging task after StartDelay
+func (s *Server) startTask(appId string, task *types.Task) {
+	s.publish("task_add", types.TaskInfoEvent{TaskId: task.ID, AppId: appId, State: TaskStaging, ClusterId: s.cluster})
+	time.AfterFunc(s.StartDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		a, ok := s.apps[appId]
+		if !ok {
+			return
+		}
+		// the task may have been replaced while staging
+		st, ok := a.starts[task.ID]
+		if !ok {
+			return
+		}
+		delete(a.starts, task.ID)
+
+		task.Status = TaskRunning
+		task.Healthy = !st.unhealthy
+		if st.fail {
+			task.Status = TaskFailed
+			task.Healthy = false
+			task.CurrentTask.Reason = "failure injected by fake swan"
+		}
+		task.CurrentTask.State = task.Status
+		s.publish("task_state_change", types.TaskInfoEvent{
+			TaskId:    task.ID,
+			AppId:     appId,
+			State:     task.Status,
+			Healthy:   task.Healthy,
+			ClusterId: s.cluster,
+		})
+		s.appChanged(a)
+	})
+}
+
+// appChanged makes the proposed version the current one once every task
+// runs it, and counts the instances
+func (s *Server) appChanged(a *app) {
+	a.app.Updated = time.Now()
+	a.app.RunningInstances = 0
+	a.app.UpdatedInstances = 0
+	done := a.app.ProposedVersion != nil
+	for _, task := range a.app.Tasks {
+		if task.Status == TaskRunning {
+			a.app.RunningInstances++
+		}
+		if a.app.ProposedVersion != nil && task.VersionID == a.app.ProposedVersion.ID {
+			a.app.UpdatedInstances++
+		}
+		done = done && a.app.ProposedVersion != nil && task.VersionID == a.app.ProposedVersion.ID &&
+			task.Status == TaskRunning
+	}
+	if done {
+		a.app.CurrentVersion = a.app.ProposedVersion
+		a.app.ProposedVersion = nil
+		a.app.State = AppNormal
+		a.app.UpdatedInstances = 0
+	}
+	s.publish("app_state_change", types.AppInfoEvent{AppId: a.app.ID, Name: a.app.Name, State: a.app.State, ClusterId: s.cluster})
+}
+
+// snapshot deep copies the app so it can be encoded outside of the lock
+func (s *Server) snapshot(a *app) types.App {
+	app := a.app
+	app.Tasks = make([]*types.Task, 0, len(a.app.Tasks))
+	for _, task := range a.app.Tasks {
+		t := *task
+		if task.CurrentTask != nil {
+			current := *task.CurrentTask
+			t.CurrentTask = &current
+		}
+		app.Tasks = append(app.Tasks, &t)
+	}
+	app.Versions = append([]string(nil), a.app.Versions...)
+	if a.app.CurrentVersion != nil {
+		current := *a.app.CurrentVersion
+		app.CurrentVersion = &current
+	}
+	if a.app.ProposedVersion != nil {
+		proposed := *a.app.ProposedVersion
+		app.ProposedVersion = &proposed
+	}
+	return app
+}
+
+// inject spends the scripted call failures of an app, it returns the status
+// to answer, or 0, and the latency to wait before answering
+func (s *Server) inject(appId string, update bool) (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[appId]
+	if !ok {
+		return 0, 0
+	}
+	status := a.faults.FailStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	switch {
+	case a.faults.FailCalls > 0:
+		a.faults.FailCalls--
+		return status, a.faults.Latency
+	case update && a.faults.FailUpdates > 0:
+		a.faults.FailUpdates--
+		return status, a.faults.Latency
+	}
+	return 0, a.faults.Latency
+}
+
+// subscribe returns the events published from now on
+func (s *Server) subscribe() (<-chan []byte, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	id := s.nextId
+	ch := make(chan []byte, 256)
+	s.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.subs, id)
+			close(ch)
+		})
+	}
+}
+
+// publish sends an event to the subscribers as a Server-Sent Event, it never
+// blocks and must be called with the lock held
+func (s *Server) publish(event string, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	msg := []byte("event: " + event + "\ndata: " + string(body) + "\n\n")
+	for _, ch := range s.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
@@ -21,16 +21,24 @@ const (
 	CodeSuccess = 0
 	// ProjectNotExist define the error return code for Project not exist
 	ProjectNotExist = 10003
+	// Unauthorized define the error return code for a missing or unknown token
+	Unauthorized = 10012
 	// ProjectStatusSuccess define the string success
 	ProjectStatusSuccess = "success"
 	// ProjectStatusCreated define the string success
 	ProjectStatusCreated = "created"
+	// ProjectStatusVerifying define the string verifying
+	ProjectStatusVerifying = "verifying"
 	// ActionContinue define the string continue
 	ActionContinue = "continue"
 	// ActionRollback define the string rollback
 	ActionRollback = "rollback"
 	// ActionStop define the string stop
 	ActionStop = "stop"
+	// ActionPause define the string pause
+	ActionPause = "pause"
+	// ActionResume define the string resume
+	ActionResume = "resume"
 )
 
 type responseCodeType struct {
@@ -72,29 +80,40 @@ func DeployAction(c *cli.Context) error {
 		if err = json.Unmarshal(content, &hamalJSON); err != nil {
 			return cli.NewExitError(fmt.Sprintf("%s", err.Error()), 1)
 		}
-		project, err := getProject(hamalJSON.Name)
+		project, err := getProject(hamalJSON.Namespace, hamalJSON.Name)
 		if err != nil {
 			return cli.NewExitError(fmt.Sprintf("%s", err.Error()), 1)
 		}
 		if project == nil {
-			if err = createProject(content); err != nil {
+			if err = createProject(hamalJSON.Namespace, content); err != nil {
 				return cli.NewExitError(fmt.Sprintf("%s", err.Error()), 1)
 			}
 			// TODO (wtzhou) we can bypass the duplicated getProject call if createProject return the object
-			if project, err = getProject(hamalJSON.Name); err != nil {
+			if project, err = getProject(hamalJSON.Namespace, hamalJSON.Name); err != nil {
 				return cli.NewExitError(fmt.Sprintf("%s", err.Error()), 1)
 			}
 		}
-		if project.Applications[0].Status == ProjectStatusSuccess {
+		app := currentApp(project)
+		if app == nil {
 			fmt.Print("Have been updated to current version")
 			return nil
 		}
-		action := nextAction(project)
+		action := nextAction(project, app)
 		switch action {
 		case ActionContinue:
-			rollingUpdateProject(project)
+			rollingUpdateProject(project, app)
 		case ActionRollback:
-			rollbackProject(project)
+			rollbackProject(project, app)
+		case ActionPause:
+			pauseProject(project, nil, true)
+		case ActionResume:
+			// the app runs again once neither it nor its project is paused
+			if app.Paused {
+				pauseProject(project, app, false)
+			}
+			if project.Paused {
+				pauseProject(project, nil, false)
+			}
 		default:
 			fmt.Printf("No this action: %s", action)
 		}
@@ -102,8 +121,13 @@ func DeployAction(c *cli.Context) error {
 	return nil
 }
 
-func getProject(projectName string) (*models.Project, error) {
-	resp, err := http.Get(cfg.GetServerFullURL() + "/projects/" + projectName)
+func getProject(namespace, projectName string) (*models.Project, error) {
+	req, err := http.NewRequest("GET", cfg.GetProjectsURL(namespace)+"/"+projectName, nil)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Authorize(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -120,6 +144,8 @@ func getProject(projectName string) (*models.Project, error) {
 	switch respCode.Code {
 	case ProjectNotExist:
 		return nil, nil
+	case Unauthorized:
+		return nil, errors.New("Unauthorized, check HAMAL_TOKEN in .hamal_cfg")
 	case CodeSuccess:
 		var respBody responseBodyType
 		if err = json.Unmarshal(body, &respBody); err != nil {
@@ -131,9 +157,10 @@ func getProject(projectName string) (*models.Project, error) {
 	}
 }
 
-func createProject(hamalByte []byte) error {
-	req, err := http.NewRequest("POST", cfg.GetServerFullURL()+"/projects", bytes.NewBuffer(hamalByte))
+func createProject(namespace string, hamalByte []byte) error {
+	req, err := http.NewRequest("POST", cfg.GetProjectsURL(namespace), bytes.NewBuffer(hamalByte))
 	req.Header.Set("Content-Type", "application/json")
+	cfg.Authorize(req)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -152,49 +179,114 @@ func createProject(hamalByte []byte) error {
 	return nil
 }
 
-func rollingUpdateProject(project *models.Project) error {
-	client := &http.Client{}
-	// TODO (wtzhou) we can support PER-app-PER-project only now
+// currentApp returns the first app which is not updated yet and whose
+// dependencies are all updated, the server refuses to start the others
+func currentApp(project *models.Project) *models.AppUpdateStage {
+	updated := make(map[string]bool)
 	for _, app := range project.Applications {
-		req, err := http.NewRequest("PUT", cfg.GetServerFullURL()+"/projects/"+project.Name+"/rollingupdate", strings.NewReader(`{"app_id":"`+app.AppId+`"}`))
-		req.Header.Set("Content-Type", "application/json")
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
+		updated[app.AppId] = app.Status == ProjectStatusSuccess
+	}
+
+	for n, app := range project.Applications {
+		if updated[app.AppId] {
+			continue
+		}
+		ready := true
+		for _, dep := range app.DependsOn {
+			ready = ready && updated[dep]
+		}
+		// the clusters are rolled one after the other
+		for _, cluster := range project.ClusterOrder {
+			if cluster == app.Cluster {
+				break
+			}
+			for _, a := range project.Applications {
+				ready = ready && (a.Cluster != cluster || updated[a.AppId])
+			}
 		}
-		defer resp.Body.Close()
-		body, _ := ioutil.ReadAll(resp.Body)
-		if resp.StatusCode == http.StatusOK {
-			fmt.Printf("Updated: %s", string(body))
-		} else {
-			return errors.New(string(body))
+		if ready {
+			return &project.Applications[n]
 		}
 	}
 	return nil
 }
 
-func rollbackProject(project *models.Project) error {
+func rollingUpdateProject(project *models.Project, app *models.AppUpdateStage) error {
 	client := &http.Client{}
-	// TODO (wtzhou) we can support PER-app-PER-project only now
-	for _, app := range project.Applications {
-		req, err := http.NewRequest("PUT", cfg.GetServerFullURL()+"/projects/"+project.Name+"/rollback", strings.NewReader(`{"app_id":"`+app.AppId+`"}`))
-		req.Header.Set("Content-Type", "application/json")
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		body, _ := ioutil.ReadAll(resp.Body)
-		if resp.StatusCode == http.StatusOK {
-			fmt.Printf("Rollbacked: %s", string(body))
-		} else {
-			return errors.New(string(body))
-		}
+	req, err := http.NewRequest("PUT", cfg.GetProjectsURL(project.Namespace)+"/"+project.Name+"/rollingupdate", strings.NewReader(`{"app_id":"`+app.AppId+`"}`))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	cfg.Authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		fmt.Printf("Updated: %s", string(body))
+	} else {
+		return errors.New(string(body))
+	}
+	return nil
+}
+
+func rollbackProject(project *models.Project, app *models.AppUpdateStage) error {
+	client := &http.Client{}
+	req, err := http.NewRequest("PUT", cfg.GetProjectsURL(project.Namespace)+"/"+project.Name+"/rollback", strings.NewReader(`{"app_id":"`+app.AppId+`"}`))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	cfg.Authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		fmt.Printf("Rollbacked: %s", string(body))
+	} else {
+		return errors.New(string(body))
+	}
+	return nil
+}
+
+// pauseProject pauses or resumes every app of the project, or only app when
+// it is set, the stages already handed to swan still complete
+func pauseProject(project *models.Project, app *models.AppUpdateStage, pause bool) error {
+	action := ActionResume
+	if pause {
+		action = ActionPause
+	}
+	path := "/" + project.Name
+	if app != nil {
+		path += "/apps/" + app.AppId
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest("PUT", cfg.GetProjectsURL(project.Namespace)+path+"/"+action, nil)
+	if err != nil {
+		return err
+	}
+	cfg.Authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		fmt.Printf("%sd: %s", strings.Title(action), string(body))
+	} else {
+		return errors.New(string(body))
 	}
 	return nil
 }
 
-func nextAction(project *models.Project) string {
+func nextAction(project *models.Project, app *models.AppUpdateStage) string {
 	if err := ui.Init(); err != nil {
 		panic(err)
 	}
@@ -206,23 +298,30 @@ func nextAction(project *models.Project) string {
 	header.Border = false
 	header.TextBgColor = ui.ColorBlue
 
-	// TODO (wtzhou) only support per-app-per-project
-	stagesSum := len(project.Applications[0].RollingUpdatePolicy)
-	currentStage := int(project.Applications[0].NextStage)
+	stagesSum := len(app.RollingUpdatePolicy)
+	currentStage := int(app.NextStage)
 	stagesArray := make([]string, stagesSum)
 
 	for i := 0; i < int(currentStage); i++ {
 		stagesArray[i] = " [" + strconv.Itoa(i) + "] " +
-			"[Updated " + strconv.Itoa(int(project.Applications[0].RollingUpdatePolicy[i].InstancesToUpdate)) +
+			"[Updated " + stageSize(app, i) +
 			" instances](fg-blue)"
 	}
-	if stagesSum > currentStage {
+	verifying := app.Status == ProjectStatusVerifying
+	paused := project.Paused || app.Paused
+	if stagesSum > currentStage && verifying {
 		stagesArray[currentStage] = "*[" + strconv.Itoa(currentStage) + "] " +
-			"[Pending update " + strconv.Itoa(int(project.Applications[0].RollingUpdatePolicy[currentStage].InstancesToUpdate)) +
+			"[Verifying " + stageSize(app, currentStage) +
+			" instances](fg-white,bg-yellow)"
+	} else if stagesSum > currentStage {
+		stagesArray[currentStage] = "*[" + strconv.Itoa(currentStage) + "] " +
+			"[Pending update " + stageSize(app, currentStage) +
 			" instances](fg-white,bg-green)"
+	}
+	if stagesSum > currentStage {
 		for i := int(currentStage) + 1; i < stagesSum; i++ {
 			stagesArray[i] = " [" + strconv.Itoa(i) + "] " +
-				"[Pending update " + strconv.Itoa(int(project.Applications[0].RollingUpdatePolicy[i].InstancesToUpdate)) +
+				"[Pending update " + stageSize(app, i) +
 				" instances](fg-white)"
 		}
 	}
@@ -230,13 +329,24 @@ func nextAction(project *models.Project) string {
 	stagesUI := ui.NewList()
 	stagesUI.Items = stagesArray
 	stagesUI.ItemFgColor = ui.ColorYellow
-	stagesUI.BorderLabel = project.Name + " Progress..."
+	stagesUI.BorderLabel = project.Name + "/" + app.AppId + " Progress..."
+	if app.Cluster != "" {
+		stagesUI.BorderLabel = project.Name + "/" + app.Cluster + "/" + app.AppId + " Progress..."
+	}
 	stagesUI.Height = 10
 	stagesUI.Width = 20
 
 	continueBar := ui.NewPar("Continue")
 	continueBar.TextFgColor = ui.ColorWhite
 	continueBar.TextBgColor = ui.ColorGreen
+	if verifying {
+		// the updated instances are not healthy yet, continue is refused
+		continueBar.Text = "Verifying..."
+		continueBar.TextBgColor = ui.ColorDefault
+	} else if paused {
+		continueBar.Text = "Paused"
+		continueBar.TextBgColor = ui.ColorDefault
+	}
 	continueBar.Height = 2
 	continueBar.Width = 5
 	continueBar.Border = false
@@ -248,6 +358,18 @@ func nextAction(project *models.Project) string {
 	rollbackBar.Width = 5
 	rollbackBar.Border = false
 
+	pauseAction := ActionPause
+	pauseBar := ui.NewPar("Pause")
+	if paused {
+		pauseAction = ActionResume
+		pauseBar.Text = "Resume"
+	}
+	pauseBar.Height = 2
+	pauseBar.TextFgColor = ui.ColorWhite
+	pauseBar.TextBgColor = ui.ColorDefault
+	pauseBar.Width = 5
+	pauseBar.Border = false
+
 	ui.Body.AddRows(
 		ui.NewRow(
 			ui.NewCol(3, 2, header),
@@ -257,6 +379,7 @@ func nextAction(project *models.Project) string {
 		),
 		ui.NewRow(
 			ui.NewCol(2, 2, rollbackBar),
+			ui.NewCol(1, 1, pauseBar),
 			ui.NewCol(1, 1, continueBar),
 		),
 	)
@@ -265,34 +388,34 @@ func nextAction(project *models.Project) string {
 	ui.Body.Align()
 	ui.Render(ui.Body)
 
-	action := ActionContinue
+	continueAction := ActionContinue
+	if verifying || paused {
+		continueAction = ActionStop
+	}
+	bars := []*ui.Par{rollbackBar, pauseBar, continueBar}
+	actions := []string{ActionRollback, pauseAction, continueAction}
+	selected := len(bars) - 1
+	action := actions[selected]
+
+	move := func(step int) {
+		if selected+step < 0 || selected+step >= len(bars) {
+			return
+		}
+		selected += step
+		highlight(bars, selected)
+		ui.Clear()
+		ui.Render(ui.Body)
+		action = actions[selected]
+	}
 
 	ui.Handle("/sys/kbd/q", func(ui.Event) {
 		ui.StopLoop()
 		action = ActionStop
 	})
-	ui.Handle("/sys/kbd/h", func(ui.Event) {
-		highlightToggle(rollbackBar, continueBar)
-		ui.Render(ui.Body)
-		action = ActionRollback
-	})
-	ui.Handle("/sys/kbd/l", func(ui.Event) {
-		highlightToggle(continueBar, rollbackBar)
-		ui.Render(ui.Body)
-		action = ActionContinue
-	})
-	ui.Handle("/sys/kbd/<left>", func(ui.Event) {
-		highlightToggle(rollbackBar, continueBar)
-		ui.Clear()
-		ui.Render(ui.Body)
-		action = ActionRollback
-	})
-	ui.Handle("/sys/kbd/<right>", func(ui.Event) {
-		highlightToggle(continueBar, rollbackBar)
-		ui.Clear()
-		ui.Render(ui.Body)
-		action = ActionContinue
-	})
+	ui.Handle("/sys/kbd/h", func(ui.Event) { move(-1) })
+	ui.Handle("/sys/kbd/l", func(ui.Event) { move(1) })
+	ui.Handle("/sys/kbd/<left>", func(ui.Event) { move(-1) })
+	ui.Handle("/sys/kbd/<right>", func(ui.Event) { move(1) })
 	ui.Handle("/sys/kbd/<enter>", func(ui.Event) {
 		ui.StopLoop()
 	})
@@ -300,9 +423,25 @@ func nextAction(project *models.Project) string {
 	return action
 }
 
-func highlightToggle(parA *ui.Par, parB *ui.Par) {
-	parA.TextFgColor = ui.ColorWhite
-	parA.TextBgColor = ui.ColorGreen
-	parB.TextFgColor = ui.ColorWhite
-	parB.TextBgColor = ui.ColorDefault
+// stageSize returns the instance count of a stage, pending percentage stages
+// are resolved by the server only when they start
+func stageSize(app *models.AppUpdateStage, stage int) string {
+	if stage < len(app.StageInstances) {
+		return strconv.FormatInt(app.StageInstances[stage], 10)
+	}
+	if p := app.RollingUpdatePolicy[stage].Percentage; p > 0 {
+		return strconv.FormatFloat(p, 'g', -1, 64) + "%"
+	}
+	return strconv.Itoa(int(app.RollingUpdatePolicy[stage].InstancesToUpdate))
+}
+
+// highlight marks the selected option bar
+func highlight(bars []*ui.Par, selected int) {
+	for n, bar := range bars {
+		bar.TextFgColor = ui.ColorWhite
+		bar.TextBgColor = ui.ColorDefault
+		if n == selected {
+			bar.TextBgColor = ui.ColorGreen
+		}
+	}
 }
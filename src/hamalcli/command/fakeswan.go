@@ -0,0 +1,87 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Dataman-Cloud/hamal/src/fakeswan"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/gin-gonic/gin"
+	"github.com/urfave/cli"
+)
+
+// NewFakeSwanCommand init the struct Cli.Command
+func NewFakeSwanCommand() cli.Command {
+	return cli.Command{
+		Name:  "fake-swan",
+		Usage: "run an in-memory swan cluster to try rollouts locally",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "listen, l",
+				Value: ":9999",
+				Usage: "Serve the swan api on `ADDR`",
+			},
+			cli.StringFlag{
+				Name:  "cluster",
+				Value: "fake",
+				Usage: "Cluster id of the apps",
+			},
+			cli.StringSliceFlag{
+				Name:  "file, f",
+				Usage: "Create the apps of the deploy file `FILE`, running their orchestration",
+			},
+			cli.IntFlag{
+				Name:  "first-step",
+				Value: fakeswan.DefaultFirstStep,
+				Usage: "Instances a new version updates before the update is proceeded",
+			},
+			cli.DurationFlag{
+				Name:  "start-delay",
+				Usage: "How long a task stages before it runs",
+			},
+			cli.DurationFlag{
+				Name:  "latency",
+				Usage: "Delay every call on the apps of the deploy files",
+			},
+		},
+		Action: FakeSwanAction,
+	}
+}
+
+// FakeSwanAction serves a fake swan cluster until interrupted, its faults
+// are scripted through PUT /fake/apps/:app_id/faults
+func FakeSwanAction(c *cli.Context) error {
+	gin.SetMode(gin.ReleaseMode)
+	swan := fakeswan.New(c.String("cluster"))
+	swan.FirstStep = c.Int("first-step")
+	swan.StartDelay = c.Duration("start-delay")
+
+	for _, file := range c.StringSlice("file") {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		var project models.Project
+		if err = json.Unmarshal(content, &project); err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: %s", file, err.Error()), 1)
+		}
+		for _, application := range project.Applications {
+			app, err := swan.AddApp(application.AppId, application.App)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("%s: %s", file, err.Error()), 1)
+			}
+			if err = swan.SetFaults(app.ID, fakeswan.Faults{Latency: c.Duration("latency")}); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			fmt.Printf("app %s: %d instances of version %s\n", app.ID, app.Instances, app.CurrentVersion.ID)
+		}
+	}
+
+	fmt.Printf("fake swan cluster %s listening on %s\n", c.String("cluster"), c.String("listen"))
+	if err := http.ListenAndServe(c.String("listen"), swan); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
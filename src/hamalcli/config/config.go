@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"reflect"
 	"strconv"
@@ -20,6 +21,8 @@ var cfg *Config
 // Config defines the conf info
 type Config struct {
 	HamalAddr string `require:"true" alias:"HAMAL_ADDR"`
+	// HamalToken is sent as bearer token when set
+	HamalToken string `require:"false" alias:"HAMAL_TOKEN"`
 }
 
 // GetConfig get config data
@@ -32,6 +35,22 @@ func GetServerFullURL() string {
 	return cfg.HamalAddr + URLPrefix
 }
 
+// GetProjectsURL return the url of the projects of a namespace, the
+// projects without namespace are in the default namespace
+func GetProjectsURL(namespace string) string {
+	if namespace == "" {
+		return GetServerFullURL() + "/projects"
+	}
+	return GetServerFullURL() + "/namespaces/" + namespace + "/projects"
+}
+
+// Authorize adds the token of the config to a request to the hamal server
+func Authorize(req *http.Request) {
+	if cfg.HamalToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.HamalToken)
+	}
+}
+
 // InitConfig init config
 func InitConfig(file string) {
 	cfg = new(Config)
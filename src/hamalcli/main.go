@@ -19,6 +19,7 @@ func main() {
 
 	hamal.Commands = []cli.Command{
 		command.NewDeployCommand(),
+		command.NewFakeSwanCommand(),
 	}
 	hamal.Run(os.Args)
 }
@@ -8,7 +8,7 @@ import (
 	"github.com/Dataman-Cloud/hamal/src/config"
 	"github.com/Dataman-Cloud/hamal/src/router"
 	"github.com/Dataman-Cloud/hamal/src/router/middleware"
-	_ "github.com/Dataman-Cloud/hamal/src/utils"
+	"github.com/Dataman-Cloud/hamal/src/utils"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -18,10 +18,16 @@ func main() {
 	flag.Parse()
 	config.InitConfig(*configFile)
 
+	authenticators, err := middleware.Authenticators(config.GetConfig())
+	if err != nil {
+		log.Fatalf("authentication error: %v", err)
+	}
+
 	log.Infof("http listen %s starting...", config.GetConfig().Addr)
+	// the event streams move the write deadline set by WriteTimeout as they go
 	server := &http.Server{
 		Addr:           config.GetConfig().Addr,
-		Handler:        router.Router(middleware.Authenticate),
+		Handler:        utils.KeepServerWriter(router.Router(middleware.Authenticate(authenticators...))),
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
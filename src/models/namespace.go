@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// DefaultNamespace holds the projects created without a namespace, the
+// routes which name no namespace address it
+const DefaultNamespace = "default"
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Namespace is a tenant owning its projects, the same project name may be
+// used in several namespaces
+type Namespace struct {
+	Name  string `json:"name"`
+	Quota Quota  `json:"quota"`
+	// Usage counts the projects and apps of the namespace
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// Quota bounds the projects and the apps of a namespace, zero is unbounded
+type Quota struct {
+	Projects int `json:"projects,omitempty"`
+	Apps     int `json:"apps,omitempty"`
+}
+
+// Usage is what a namespace holds
+type Usage struct {
+	Projects int `json:"projects"`
+	Apps     int `json:"apps"`
+}
+
+// ProjectKey identifies a project across namespaces, the projects of the
+// default namespace keep their bare name
+func ProjectKey(namespace, name string) string {
+	if namespace == "" || namespace == DefaultNamespace {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// Key identifies the project across namespaces
+func (p *Project) Key() string {
+	return ProjectKey(p.Namespace, p.Name)
+}
+
+// InNamespace reports whether the project belongs to namespace
+func (p *Project) InNamespace(namespace string) bool {
+	return ProjectKey(p.Namespace, "") == ProjectKey(namespace, "")
+}
+
+// ValidateNamespace checks a namespace name, a dns label
+func ValidateNamespace(namespace string) error {
+	if len(namespace) > 63 || !namespacePattern.MatchString(namespace) {
+		return errors.New("invalid namespace " + namespace + ", expect lower case letters, digits and dashes")
+	}
+	return nil
+}
+
+// ValidateName checks the name and the namespace of a project
+func (p *Project) ValidateName() error {
+	if p.Name == "" || strings.Contains(p.Name, "/") {
+		return errors.New("invalid project name " + p.Name)
+	}
+	if p.Namespace == "" {
+		return nil
+	}
+	return ValidateNamespace(p.Namespace)
+}
@@ -0,0 +1,40 @@
+package models
+
+import "testing"
+
+func TestProjectKey(t *testing.T) {
+	for _, tc := range []struct{ namespace, want string }{
+		{"", "shop"},
+		{DefaultNamespace, "shop"},
+		{"payments", "payments/shop"},
+	} {
+		project := &Project{Name: "shop", Namespace: tc.namespace}
+		if key := project.Key(); key != tc.want {
+			t.Errorf("project of namespace %q keyed %q, want %q", tc.namespace, key, tc.want)
+		}
+	}
+	if !(&Project{Name: "shop"}).InNamespace(DefaultNamespace) {
+		t.Error("project without namespace not in the default namespace")
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, project := range []*Project{
+		{Name: "shop"},
+		{Name: "shop", Namespace: "payments-eu"},
+	} {
+		if err := project.ValidateName(); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, project := range []*Project{
+		{},
+		{Name: "payments/shop"},
+		{Name: "shop", Namespace: "Payments"},
+		{Name: "shop", Namespace: "-payments"},
+	} {
+		if err := project.ValidateName(); err == nil {
+			t.Errorf("project %q of namespace %q accepted", project.Name, project.Namespace)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// Plan describes what the rollout of a project would do, without doing it
+type Plan struct {
+	Name         string    `json:"name"`
+	Applications []AppPlan `json:"applications"`
+}
+
+// AppPlan describes the rollout of one app of a plan
+type AppPlan struct {
+	AppId          string         `json:"app_id"`
+	State          string         `json:"state"`
+	CurrentVersion *types.Version `json:"current_version"`
+	TargetVersion  types.Version  `json:"target_version"`
+	Changes        []FieldDiff    `json:"changes"`
+	Stages         []StagePlan    `json:"stages"`
+	// NextStage is the stage a rolling update would start, -1 when no
+	// stage can be started and Blocked tells why
+	NextStage int64  `json:"next_stage"`
+	Blocked   string `json:"blocked,omitempty"`
+}
+
+// StagePlan describes one stage of an AppPlan
+type StagePlan struct {
+	Stage     int64  `json:"stage"`
+	Instances int64  `json:"instances"`
+	Trigger   string `json:"trigger"`
+	State     string `json:"state"`
+}
+
+// FieldDiff is a field whose value differs between two versions, the field
+// is the json path of the value, e.g. container.docker.image
+type FieldDiff struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// VersionDiff is the difference between two versions of an app grouped by
+// what the changes affect, secret looking env values are masked
+type VersionDiff struct {
+	AppId        string      `json:"app_id"`
+	From         string      `json:"from"`
+	To           string      `json:"to"`
+	Image        []FieldDiff `json:"image"`
+	Command      []FieldDiff `json:"command"`
+	Resources    []FieldDiff `json:"resources"`
+	Env          []FieldDiff `json:"env"`
+	Labels       []FieldDiff `json:"labels"`
+	HealthChecks []FieldDiff `json:"health_checks"`
+	Constraints  []FieldDiff `json:"constraints"`
+	PortMappings []FieldDiff `json:"port_mappings"`
+	Volumes      []FieldDiff `json:"volumes"`
+	Other        []FieldDiff `json:"other"`
+}
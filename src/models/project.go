@@ -1,39 +1,275 @@
 package models
 
 import (
+	"errors"
+	"strings"
+	"time"
+
 	"github.com/Dataman-Cloud/swan/src/types"
 )
 
+const (
+	// TriggerManual stages wait for an explicit rolling update request
+	TriggerManual = "manual"
+	// TriggerAuto stages start as soon as the previous stage is done
+	TriggerAuto = "auto"
+	// TriggerDelay stages start once the previous stage has been done for
+	// the given duration, e.g. "delay:30m"
+	TriggerDelay = "delay"
+)
+
+// Rollout states of an app, the reconciler moves the apps between them
+const (
+	// StatePending waits for the next stage to be started
+	StatePending = "pending"
+	// StateUpdating waits for swan to update the instances of the stage
+	StateUpdating = "updating"
+	// StateVerifying waits for the updated instances to stay healthy
+	StateVerifying = "verifying"
+	// StatePaused is not advanced until it is resumed
+	StatePaused = "paused"
+	// StateSucceeded has every instance updated
+	StateSucceeded = "succeeded"
+	// StateFailed needs a manual rollback
+	StateFailed = "failed"
+	// StateRolledBack had its update cancelled
+	StateRolledBack = "rolled-back"
+)
+
+// Actions taken, besides failing the app, when a stage timeout or the
+// progress deadline of the project expires
+const (
+	// DeadlineRollback cancels the update in swan
+	DeadlineRollback = "rollback"
+	// DeadlinePause pauses the whole project
+	DeadlinePause = "pause"
+	// DeadlineNotify only reports the failure
+	DeadlineNotify = "notify"
+)
+
 type Project struct {
-	Name         string           `json:"name"`
+	Name string `json:"name"`
+	// Namespace scopes the name of the project, DefaultNamespace when empty
+	Namespace    string           `json:"namespace,omitempty"`
 	CreateTime   string           `json:"createtime"`
 	Applications []AppUpdateStage `json:"applications"`
 	Status       int              `json:"-"`
+	Paused       bool             `json:"paused"`
+	// Labels select the projects the authorization policy grants roles
+	// on, e.g. team=payments
+	Labels map[string]string `json:"labels,omitempty"`
+	// ProgressDeadline fails an app whose stage makes no progress, no more
+	// updated task running, for that long, e.g. "10m"
+	ProgressDeadline string `json:"progress_deadline,omitempty"`
+	OnDeadline       string `json:"on_deadline,omitempty"`
+	// Notifications receive the rollout events of the project on top of the
+	// sinks configured for every project
+	Notifications []NotifySink `json:"notifications,omitempty"`
+	// ClusterOrder rolls the apps of a cluster only once the apps of the
+	// clusters before it are all updated
+	ClusterOrder []string          `json:"cluster_order,omitempty"`
+	Clusters     []ClusterProgress `json:"clusters,omitempty"`
+}
+
+// ClusterProgress sums up the rollout of the apps of a cluster
+type ClusterProgress struct {
+	Cluster   string `json:"cluster"`
+	State     string `json:"state"`
+	Apps      int    `json:"apps"`
+	Pending   int    `json:"pending"`
+	Updating  int    `json:"updating"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
 }
 
 type AppUpdateStage struct {
 	AppId               string            `json:"app_id"`
+	Cluster             string            `json:"cluster,omitempty"`
 	App                 types.Version     `json:"orchestration"`
 	RollingUpdatePolicy []AppUpdatePolicy `json:"rolling_update_policy"`
+	DependsOn           []string          `json:"depends_on,omitempty"`
+	Paused              bool              `json:"paused"`
 	NextStage           int64             `json:"next_stage"`
 	Status              string            `json:"status"`
+	State               string            `json:"state"`
+	Transitions         []Transition      `json:"transitions,omitempty"`
+	StagesStarted       int64             `json:"stages_started"`
+	StageInstances      []int64           `json:"stage_instances,omitempty"`
+	Approvals           []Approval        `json:"approvals,omitempty"`
+	StageStartedAt      string            `json:"stage_started_at,omitempty"`
+	StageCompletedAt    string            `json:"stage_completed_at,omitempty"`
+	UpdatedTasks        int64             `json:"updated_tasks,omitempty"`
+	ProgressAt          string            `json:"progress_at,omitempty"`
+	HealthySince        string            `json:"healthy_since,omitempty"`
+	PostHooksRun        int64             `json:"post_hooks_run,omitempty"`
+	HookResults         []HookResult      `json:"hook_results,omitempty"`
+	Reason              string            `json:"reason,omitempty"`
 }
 
+// Transition records a state change of an app rollout
+type Transition struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Stage  int64  `json:"stage"`
+	Reason string `json:"reason,omitempty"`
+	Time   string `json:"time"`
+}
+
+// AppUpdatePolicy defines one stage of the rollout, the stage updates either
+// InstancesToUpdate instances or Percentage percent of the app instances
 type AppUpdatePolicy struct {
-	InstancesToUpdate int64  `json:"instances_to_update"`
-	Trigger           string `json:"trigger"`
-	//RollbackPolicy    AppRollbackPolicy `json:"rollback_policy"`
+	InstancesToUpdate int64             `json:"instances_to_update"`
+	Percentage        float64           `json:"percentage,omitempty"`
+	MaxUnavailable    int64             `json:"max_unavailable,omitempty"`
+	Trigger           string            `json:"trigger"`
+	RollbackPolicy    AppRollbackPolicy `json:"rollback_policy"`
+	Approval          *ApprovalPolicy   `json:"approval,omitempty"`
+	// Timeout fails the app when the stage is not done that long after it
+	// started, e.g. "30m"
+	Timeout   string      `json:"timeout,omitempty"`
+	OnTimeout string      `json:"on_timeout,omitempty"`
+	Hooks     *StageHooks `json:"hooks,omitempty"`
 }
 
+// Hook phases, pre hooks run before the stage is handed to swan and post
+// hooks once swan updated the stage and it stayed healthy
+const (
+	HookPre  = "pre"
+	HookPost = "post"
+)
+
+// StageHooks are called around a stage, a failing pre hook keeps the stage
+// from starting and a failing post hook fails the app
+type StageHooks struct {
+	Pre  []Hook `json:"pre,omitempty"`
+	Post []Hook `json:"post,omitempty"`
+}
+
+// Hook is an HTTP endpoint called around a stage. It defaults to a POST of
+// the stage as json, a 10s timeout, no retry and any 2xx status.
+type Hook struct {
+	Name         string            `json:"name,omitempty"`
+	URL          string            `json:"url"`
+	Method       string            `json:"method,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Body         string            `json:"body,omitempty"`
+	Timeout      string            `json:"timeout,omitempty"`
+	Retries      int               `json:"retries,omitempty"`
+	ExpectStatus []int             `json:"expect_status,omitempty"`
+}
+
+// HookResult records a hook call in the rollout history
+type HookResult struct {
+	Stage    int64  `json:"stage"`
+	Phase    string `json:"phase"`
+	Name     string `json:"name"`
+	Attempts int    `json:"attempts"`
+	Status   int    `json:"status,omitempty"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Success  bool   `json:"success"`
+	Time     string `json:"time"`
+}
+
+// ApprovalPolicy holds a stage until Required distinct approvers approved
+// it, any approver is accepted when Approvers is empty
+type ApprovalPolicy struct {
+	Required  int      `json:"required"`
+	Approvers []string `json:"approvers,omitempty"`
+}
+
+// Approval records who approved a stage and when
+type Approval struct {
+	Stage    int64  `json:"stage"`
+	Approver string `json:"approver"`
+	Time     string `json:"time"`
+}
+
+// ParseTrigger returns the trigger type of the stage and, for delay
+// triggers, how long to wait after the previous stage is done
+func (p AppUpdatePolicy) ParseTrigger() (string, time.Duration, error) {
+	kv := strings.SplitN(p.Trigger, ":", 2)
+	switch kv[0] {
+	case "", TriggerManual:
+		if len(kv) == 2 {
+			break
+		}
+		return TriggerManual, 0, nil
+	case TriggerAuto:
+		if len(kv) == 2 {
+			break
+		}
+		return TriggerAuto, 0, nil
+	case TriggerDelay:
+		if len(kv) != 2 {
+			break
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d < 0 {
+			break
+		}
+		return TriggerDelay, d, nil
+	}
+	return "", 0, errors.New("invalid trigger " + p.Trigger)
+}
+
+// Notification sink types
+const (
+	// SinkWebhook posts the events as json
+	SinkWebhook = "webhook"
+	// SinkSlack posts the events to a Slack compatible incoming webhook
+	SinkSlack = "slack"
+	// SinkEmail mails the events through the configured SMTP server
+	SinkEmail = "email"
+)
+
+// NotifySink receives the rollout events listed in Events, or all of them
+// when Events is empty
+type NotifySink struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url,omitempty"`
+	To     []string `json:"to,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// ParseDeadline returns the duration of a stage timeout or of a progress
+// deadline, zero when it is not set, and checks the action taken on expiry
+func ParseDeadline(deadline, action string) (time.Duration, error) {
+	switch action {
+	case "", DeadlineRollback, DeadlinePause, DeadlineNotify:
+	default:
+		return 0, errors.New("invalid deadline action " + action)
+	}
+	if deadline == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(deadline)
+	if err != nil || d <= 0 {
+		return 0, errors.New("invalid deadline " + deadline)
+	}
+	return d, nil
+}
+
+// AppRollbackPolicy rolls the app back automatically once RollbackCondition
+// failures of the proposed version tasks are observed during the stage
 type AppRollbackPolicy struct {
 	AutoRollback      bool  `json:"auto_rollback"`
 	RollbackCondition int64 `json:"rollback_condition"`
 }
 
 type Hamal struct {
-	Name string `json:"name"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
 }
 
 type RollPolicy struct {
 	AppId string `json:"app_id"`
 }
+
+type RevertPolicy struct {
+	VersionId string `json:"version_id"`
+}
+
+type ApprovePolicy struct {
+	Approver string `json:"approver"`
+}
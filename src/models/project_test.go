@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTrigger(t *testing.T) {
+	for _, tc := range []struct {
+		trigger string
+		want    string
+		delay   time.Duration
+	}{
+		{"", TriggerManual, 0},
+		{"manual", TriggerManual, 0},
+		{"auto", TriggerAuto, 0},
+		{"delay:30m", TriggerDelay, 30 * time.Minute},
+		{"delay:0s", TriggerDelay, 0},
+	} {
+		trigger, delay, err := (AppUpdatePolicy{Trigger: tc.trigger}).ParseTrigger()
+		if err != nil || trigger != tc.want || delay != tc.delay {
+			t.Errorf("trigger %q parsed as %s %s (%v), want %s %s", tc.trigger, trigger, delay, err, tc.want, tc.delay)
+		}
+	}
+
+	for _, trigger := range []string{"manual:1m", "auto:1m", "delay", "delay:", "delay:soon", "delay:-1m", "later"} {
+		if _, _, err := (AppUpdatePolicy{Trigger: trigger}).ParseTrigger(); err == nil {
+			t.Errorf("invalid trigger %q accepted", trigger)
+		}
+	}
+}
+
+func TestParseDeadline(t *testing.T) {
+	for _, tc := range []struct {
+		deadline, action string
+		want             time.Duration
+	}{
+		{"", "", 0},
+		{"", DeadlinePause, 0},
+		{"10m", "", 10 * time.Minute},
+		{"90s", DeadlineRollback, 90 * time.Second},
+		{"1h", DeadlineNotify, time.Hour},
+	} {
+		d, err := ParseDeadline(tc.deadline, tc.action)
+		if err != nil || d != tc.want {
+			t.Errorf("deadline %q %q parsed as %s (%v), want %s", tc.deadline, tc.action, d, err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ deadline, action string }{
+		{"0s", ""},
+		{"-1m", ""},
+		{"soon", ""},
+		{"10m", "retry"},
+		{"", "retry"},
+	} {
+		if _, err := ParseDeadline(tc.deadline, tc.action); err == nil {
+			t.Errorf("invalid deadline %q %q accepted", tc.deadline, tc.action)
+		}
+	}
+}
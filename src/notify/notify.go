@@ -0,0 +1,240 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Rollout events sent to the sinks
+const (
+	EventProjectCreated = "project_created"
+	EventStageStarted   = "stage_started"
+	EventStageCompleted = "stage_completed"
+	EventRollback       = "rollback"
+	EventFailure        = "failure"
+	EventApprovalNeeded = "approval_needed"
+)
+
+const (
+	// QueueSize is how many deliveries may wait for a worker, events are
+	// dropped once it is full rather than blocking the caller
+	QueueSize = 256
+	// Workers is how many deliveries are sent concurrently
+	Workers = 4
+	// MaxAttempts is how many times a delivery is tried
+	MaxAttempts = 5
+	// RetryDelay is doubled after every failed attempt
+	RetryDelay = 2 * time.Second
+)
+
+// Event is a rollout event of a project
+type Event struct {
+	Type    string `json:"type"`
+	Project string `json:"project"`
+	AppId   string `json:"app_id,omitempty"`
+	Stage   int64  `json:"stage"`
+	Reason  string `json:"reason,omitempty"`
+	Time    string `json:"time"`
+}
+
+func (e *Event) String() string {
+	s := "project " + e.Project
+	if e.AppId != "" {
+		s += fmt.Sprintf(" app %s stage %d", e.AppId, e.Stage)
+	}
+	s += ": " + strings.Replace(e.Type, "_", " ", -1)
+	if e.Reason != "" {
+		s += ", " + e.Reason
+	}
+	return s
+}
+
+// SMTPConfig is the server email sinks are sent through
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+type delivery struct {
+	sink    models.NotifySink
+	event   *Event
+	attempt int
+}
+
+// Notifier delivers events to the sinks in the background, retrying the
+// failed deliveries
+type Notifier struct {
+	sinks  []models.NotifySink
+	smtp   SMTPConfig
+	client *http.Client
+	queue  chan *delivery
+}
+
+// NewNotifier returns a Notifier sending every event to sinks, on top of the
+// sinks given to Notify
+func NewNotifier(sinks []models.NotifySink, smtp SMTPConfig) *Notifier {
+	n := &Notifier{
+		sinks:  sinks,
+		smtp:   smtp,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *delivery, QueueSize),
+	}
+	for i := 0; i < Workers; i++ {
+		go n.work()
+	}
+	return n
+}
+
+// Notify queues e for the global sinks and the given sinks, it never blocks
+func (n *Notifier) Notify(sinks []models.NotifySink, e *Event) {
+	for _, sink := range append(append([]models.NotifySink{}, n.sinks...), sinks...) {
+		if subscribed(sink, e.Type) {
+			n.enqueue(&delivery{sink: sink, event: e})
+		}
+	}
+}
+
+func (n *Notifier) enqueue(d *delivery) {
+	select {
+	case n.queue <- d:
+	default:
+		log.Errorf("notification queue is full, drop %s event of project %s", d.event.Type, d.event.Project)
+	}
+}
+
+func (n *Notifier) work() {
+	for d := range n.queue {
+		err := n.send(d.sink, d.event)
+		if err == nil {
+			continue
+		}
+
+		d.attempt++
+		if d.attempt >= MaxAttempts {
+			log.Errorf("notify %s of %s event of project %s error, give up: %v",
+				d.sink.Type, d.event.Type, d.event.Project, err)
+			continue
+		}
+		log.Warnf("notify %s of %s event of project %s error, retry: %v",
+			d.sink.Type, d.event.Type, d.event.Project, err)
+		// wait outside of the worker so other deliveries are not held
+		retry := d
+		time.AfterFunc(RetryDelay<<uint(d.attempt-1), func() { n.enqueue(retry) })
+	}
+}
+
+func (n *Notifier) send(sink models.NotifySink, e *Event) error {
+	switch sink.Type {
+	case models.SinkWebhook:
+		return n.post(sink.URL, e)
+	case models.SinkSlack:
+		return n.post(sink.URL, map[string]string{"text": e.String()})
+	case models.SinkEmail:
+		return n.mail(sink.To, e)
+	}
+	return errors.New("unknown sink type " + sink.Type)
+}
+
+func (n *Notifier) post(target string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("unexpected status " + resp.Status)
+	}
+	return nil
+}
+
+func (n *Notifier) mail(to []string, e *Event) error {
+	if n.smtp.Addr == "" {
+		return errors.New("no smtp server configured")
+	}
+
+	var auth smtp.Auth
+	if n.smtp.Username != "" {
+		host := strings.Split(n.smtp.Addr, ":")[0]
+		auth = smtp.PlainAuth("", n.smtp.Username, n.smtp.Password, host)
+	}
+	// the headers only hold parsed addresses and an encoded subject, a
+	// recipient or a project name cannot add headers
+	recipients := make([]string, 0, len(to))
+	headers := make([]string, 0, len(to))
+	for _, r := range to {
+		addr, err := mail.ParseAddress(r)
+		if err != nil {
+			return fmt.Errorf("invalid email recipient %q", r)
+		}
+		recipients = append(recipients, addr.Address)
+		headers = append(headers, addr.String())
+	}
+	subject := "[hamal] " + e.Project + " " + strings.Replace(e.Type, "_", " ", -1)
+	msg := "From: " + n.smtp.From + "\r\n" +
+		"To: " + strings.Join(headers, ", ") + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"\r\n" + e.String() + "\r\n" + "at " + e.Time + "\r\n"
+	return smtp.SendMail(n.smtp.Addr, auth, n.smtp.From, recipients, []byte(msg))
+}
+
+func subscribed(sink models.NotifySink, event string) bool {
+	if len(sink.Events) == 0 {
+		return true
+	}
+	for _, e := range sink.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSink checks the settings of a sink
+func ValidateSink(sink models.NotifySink) error {
+	switch sink.Type {
+	case models.SinkWebhook, models.SinkSlack:
+		u, err := url.Parse(sink.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("invalid " + sink.Type + " url " + sink.URL)
+		}
+	case models.SinkEmail:
+		if len(sink.To) == 0 {
+			return errors.New("email sink without recipient")
+		}
+		for _, to := range sink.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("invalid email recipient %q", to)
+			}
+		}
+	default:
+		return errors.New("unknown sink type " + sink.Type)
+	}
+	for _, e := range sink.Events {
+		switch e {
+		case EventProjectCreated, EventStageStarted, EventStageCompleted,
+			EventRollback, EventFailure, EventApprovalNeeded:
+		default:
+			return errors.New("unknown event " + e)
+		}
+	}
+	return nil
+}
@@ -1,21 +1,84 @@
 package middleware
 
 import (
+	"errors"
+	"strings"
+
+	"github.com/Dataman-Cloud/hamal/src/auth"
+	"github.com/Dataman-Cloud/hamal/src/config"
+	"github.com/Dataman-Cloud/hamal/src/utils"
+
+	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
 )
 
-// Authenticate may add the authenticate methods.
-func Authenticate(ctx *gin.Context) {
-	ctx.Next()
+// Unauthorized is answered to the requests without a known token
+const Unauthorized = "401-10012"
+
+// Authenticate identifies the caller by the bearer token of the request with
+// the first authenticator which knows it. The token is read from the
+// Authorization header, or the access_token query for the event streams
+// opened by browsers. Every request is let through when no authenticator is
+// configured.
+func Authenticate(authenticators ...auth.Authenticator) gin.HandlerFunc {
+	if len(authenticators) == 0 {
+		log.Warn("no authenticator configured, the api is open to anyone")
+		return func(ctx *gin.Context) {
+			ctx.Next()
+		}
+	}
+
+	return func(ctx *gin.Context) {
+		token := bearerToken(ctx)
+		if token == "" {
+			unauthorized(ctx, errors.New("missing bearer token"))
+			return
+		}
+		for _, a := range authenticators {
+			identity, err := a.Authenticate(token)
+			if err == auth.ErrUnknownToken {
+				continue
+			}
+			if err != nil {
+				unauthorized(ctx, err)
+				return
+			}
+			auth.SetIdentity(ctx, identity)
+			ctx.Next()
+			return
+		}
+		unauthorized(ctx, errors.New("invalid bearer token"))
+	}
+}
+
+func bearerToken(ctx *gin.Context) string {
+	header := ctx.Request.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ctx.Query("access_token")
+}
+
+func unauthorized(ctx *gin.Context, err error) {
+	log.Warnf("%s %s from %s unauthorized: %v", ctx.Request.Method, ctx.Request.URL.Path, ctx.ClientIP(), err)
+	ctx.Header("WWW-Authenticate", `Bearer realm="hamal"`)
+	utils.ErrorResponse(ctx, utils.NewError(Unauthorized, err))
+	ctx.Abort()
 }
 
-// CORSMiddleware adds the CORS headers
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware adds the CORS headers for the allowed origins, "*" allows
+// any. Credentials are never allowed, the api takes bearer tokens rather
+// than cookies.
+func CORSMiddleware(origins []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		if origin := allowedOrigin(origins, c.Request.Header.Get("Origin")); origin != "" {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+			if origin != "*" {
+				c.Writer.Header().Add("Vary", "Origin")
+			}
+		}
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -25,3 +88,43 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func allowedOrigin(origins []string, origin string) string {
+	for _, o := range origins {
+		if o == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// Authenticators returns the authenticators configured, none when the api
+// is left open
+func Authenticators(c *config.Config) ([]auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+	if c.CredentialsFile != "" {
+		tokens, err := auth.NewTokenFile(c.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	if c.JWTJWKS != "" {
+		jwt, err := auth.NewJWT(auth.JWTConfig{
+			JWKS:        c.JWTJWKS,
+			Issuer:      c.JWTIssuer,
+			Audience:    c.JWTAudience,
+			NameClaim:   c.JWTNameClaim,
+			GroupsClaim: c.JWTGroupsClaim,
+			Refresh:     c.JWTJWKSRefresh,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	return authenticators, nil
+}
@@ -1,9 +1,11 @@
 package router
 
 import (
+	"strings"
 	"time"
 
 	"github.com/Dataman-Cloud/hamal/src/api"
+	"github.com/Dataman-Cloud/hamal/src/config"
 	"github.com/Dataman-Cloud/hamal/src/router/middleware"
 	"github.com/Dataman-Cloud/hamal/src/utils"
 
@@ -18,24 +20,60 @@ func Router(middlewares ...gin.HandlerFunc) *gin.Engine {
 
 	r.Use(gin.Recovery())
 	r.Use(utils.Ginrus(log.StandardLogger(), time.RFC3339Nano, false))
-	r.Use(middleware.CORSMiddleware())
-	r.Use(middlewares...)
+	r.Use(middleware.CORSMiddleware(corsOrigins()))
 
 	service := api.InitHamalControl()
-	hv1 := r.Group("/v1/hamal")
+	// ping stays open for the health checks of load balancers
+	r.GET("/v1/hamal/ping", service.Ping)
+
+	hv1 := r.Group("/v1/hamal", middlewares...)
 	{
-		hv1.GET("/ping", service.Ping)
-		hv1.POST("/projects", service.CreateOrUpdateProject)
-		hv1.PUT("/projects", service.UpdateProject)
-		hv1.GET("/projects", service.GetProjects)
-		//hv1.DELETE("/projects/:name", service.DeleteProjects)
-		hv1.GET("/projects/:name", service.GetProject)
-		hv1.PUT("/projects/:name/rollingupdate", service.RollingUpdate)
-		hv1.PUT("/projects/:name/rollback", service.Rollback)
+		// the routes without namespace address the default namespace
+		projectRoutes(hv1, service)
+		projectRoutes(hv1.Group("/namespaces/:ns"), service)
+		hv1.GET("/namespaces", service.GetNamespaces)
+		hv1.GET("/namespaces/:ns", service.GetNamespace)
+
+		hv1.GET("/audit", service.GetAudit)
 
 		hv1.GET("/apps/:app_id", service.GetApp)
 		hv1.GET("/versions/:app_id", service.GetAppVersions)
+		hv1.GET("/versions/:app_id/diff", service.DiffAppVersions)
 	}
 
 	return r
 }
+
+// projectRoutes adds the routes of the projects of a namespace to g
+func projectRoutes(g *gin.RouterGroup, service *api.HamalControl) {
+	g.POST("/projects", service.Audited("create", service.CreateOrUpdateProject))
+	g.PUT("/projects", service.Audited("update", service.UpdateProject))
+	g.GET("/projects", service.GetProjects)
+	//g.DELETE("/projects/:name", service.DeleteProjects)
+	g.GET("/projects/:name", service.GetProject)
+	g.GET("/projects/:name/events", service.Events)
+	g.GET("/projects/:name/ws", service.EventsWebSocket)
+	g.PUT("/projects/:name/rollingupdate", service.Audited("rollingupdate", service.RollingUpdate))
+	g.PUT("/projects/:name/rollback", service.Audited("rollback", service.Rollback))
+	g.POST("/projects/:name/reconcile", service.Audited("reconcile", service.Reconcile))
+	g.PUT("/projects/:name/pause", service.Audited("pause", service.PauseProject))
+	g.PUT("/projects/:name/resume", service.Audited("resume", service.ResumeProject))
+	g.PUT("/projects/:name/apps/:app_id/pause", service.Audited("pause", service.PauseProject))
+	g.PUT("/projects/:name/apps/:app_id/resume", service.Audited("resume", service.ResumeProject))
+	g.POST("/projects/:name/plan", service.Plan)
+	g.POST("/projects/:name/apps/:app_id/revert", service.Audited("revert", service.Revert))
+	g.POST("/projects/:name/apps/:app_id/stages/:n/approve", service.Audited("approve", service.Approve))
+}
+
+func corsOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(config.GetConfig().CORSOrigins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	if len(origins) == 0 {
+		return []string{"*"}
+	}
+	return origins
+}
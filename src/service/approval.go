@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+// Approve records the approval of a stage by approver and returns the
+// approvals of the app
+func (hs *HamalService) Approve(projectName, appId string, stage int64, approver string) ([]models.Approval, error) {
+	var approvals []models.Approval
+	_, err := hs.updateProject(projectName, func(project *models.Project) (bool, error) {
+		application := findApp(project, appId)
+		if application == nil {
+			return false, errors.New("app " + appId + " not exist in project " + projectName)
+		}
+		if stage < 0 || int(stage) >= len(application.RollingUpdatePolicy) {
+			return false, fmt.Errorf("app %s has no stage %d", appId, stage)
+		}
+		policy := application.RollingUpdatePolicy[stage].Approval
+		if policy == nil || policy.Required == 0 {
+			return false, fmt.Errorf("stage %d of app %s needs no approval", stage, appId)
+		}
+		if stage < application.StagesStarted {
+			return false, fmt.Errorf("stage %d of app %s is already started", stage, appId)
+		}
+		if len(policy.Approvers) > 0 && !contains(policy.Approvers, approver) {
+			return false, fmt.Errorf("%s is not allowed to approve stage %d of app %s", approver, stage, appId)
+		}
+		for _, a := range application.Approvals {
+			if a.Stage == stage && a.Approver == approver {
+				return false, fmt.Errorf("%s already approved stage %d of app %s", approver, stage, appId)
+			}
+		}
+
+		application.Approvals = append(application.Approvals, models.Approval{
+			Stage:    stage,
+			Approver: approver,
+			Time:     time.Now().Format(time.RFC3339Nano),
+		})
+		approvals = application.Approvals
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approvals, nil
+}
+
+// approvalPending tells why stage cannot start yet, or returns an empty
+// string when its approval gate is satisfied
+func approvalPending(application models.AppUpdateStage, stage int64) string {
+	policy := application.RollingUpdatePolicy[stage].Approval
+	if policy == nil {
+		return ""
+	}
+
+	approved := 0
+	for _, a := range application.Approvals {
+		if a.Stage == stage {
+			approved++
+		}
+	}
+	if approved >= policy.Required {
+		return ""
+	}
+	return fmt.Sprintf("stage %d waits for %d more approvals", stage, policy.Required-approved)
+}
+
+func validateApprovals(application models.AppUpdateStage) error {
+	for n, rp := range application.RollingUpdatePolicy {
+		if rp.Approval == nil {
+			continue
+		}
+		if rp.Approval.Required < 0 {
+			return fmt.Errorf("app %s stage %d: negative required approvals", application.AppId, n)
+		}
+		if len(rp.Approval.Approvers) > 0 && rp.Approval.Required > len(rp.Approval.Approvers) {
+			return fmt.Errorf("app %s stage %d: %d approvals required from %d approvers",
+				application.AppId, n, rp.Approval.Required, len(rp.Approval.Approvers))
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
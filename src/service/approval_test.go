@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func TestApprovalGatesStage(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	policies := stages(models.TriggerManual, models.TriggerAuto)
+	policies[1].Approval = &models.ApprovalPolicy{Required: 2, Approvers: []string{"alice", "bob"}}
+	ts.create(t, "shop", "web", policies...)
+
+	if _, err := ts.Approve("shop", "web", 0, "alice"); err == nil {
+		t.Error("approval of a stage which needs none passed")
+	}
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitStage(t, "shop", "web", 1)
+
+	if _, err := ts.Approve("shop", "web", 1, "mallory"); err == nil {
+		t.Error("approval by someone not listed passed")
+	}
+	if _, err := ts.Approve("shop", "web", 1, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Approve("shop", "web", 1, "alice"); err == nil {
+		t.Error("second approval by the same approver passed")
+	}
+	// the auto trigger waits for the second approval
+	ts.hold(t, "shop", "web", 100*time.Millisecond)
+	if err := ts.RollingUpdate("shop", "web"); err == nil {
+		t.Error("rolling update of a stage waiting for approval passed")
+	}
+
+	approvals, err := ts.Approve("shop", "web", 1, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 2 {
+		t.Errorf("%d approvals recorded, want 2", len(approvals))
+	}
+	ts.waitState(t, "shop", "web", models.StateSucceeded)
+	if _, err := ts.Approve("shop", "web", 1, "carol"); err == nil {
+		t.Error("approval of a started stage passed")
+	}
+}
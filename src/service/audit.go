@@ -0,0 +1,105 @@
+package service
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/audit"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/swanclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// auditSwan records a change hamal made in swan for the given reason, with
+// the answer of swan, stage is the stage started or the last one before a
+// rollback
+func (hs *HamalService) auditSwan(project *models.Project, appId string, stage int64, action, reason string, reply swanclient.Reply, err error) {
+	record := &audit.Record{
+		Identity:  audit.SystemIdentity,
+		Auth:      audit.AuthSystem,
+		Action:    action,
+		Reason:    reason,
+		Method:    reply.Method,
+		Path:      reply.Path,
+		Namespace: project.Namespace,
+		Project:   project.Name,
+		AppId:     appId,
+		Status:    reply.StatusCode,
+		Outcome:   audit.OutcomeSuccess,
+		Response:  reply.Body,
+	}
+	if stage >= 0 {
+		record.Stage = &stage
+	}
+	if err != nil {
+		record.Outcome = audit.OutcomeFailure
+		if reply.StatusCode == 0 {
+			// swan was not reached
+			record.Response = err.Error()
+		}
+	}
+	hs.appendAudit(record)
+}
+
+// auditHooks records the hooks of a call, the results follow the order of
+// the hooks
+func (hs *HamalService) auditHooks(call *hookCall) {
+	action := audit.ActionPreHook
+	if call.phase == models.HookPost {
+		action = audit.ActionPostHook
+	}
+	for n, result := range call.results {
+		hook := call.hooks[n]
+		method := hook.Method
+		if method == "" {
+			method = "POST"
+		}
+		stage := call.stage
+		record := &audit.Record{
+			Identity:  audit.SystemIdentity,
+			Auth:      audit.AuthSystem,
+			Action:    action,
+			Reason:    "hook " + result.Name,
+			Method:    method,
+			Path:      hookPath(hook.URL),
+			Namespace: call.namespace,
+			Project:   call.name,
+			AppId:     call.appId,
+			Stage:     &stage,
+			Status:    result.Status,
+			Outcome:   audit.OutcomeSuccess,
+			Response:  result.Output,
+		}
+		if !result.Success {
+			record.Outcome = audit.OutcomeFailure
+			if record.Response == "" {
+				record.Response = result.Error
+			}
+		}
+		hs.appendAudit(record)
+	}
+}
+
+// hookPath is the url of a hook without its credentials and query, which
+// may carry tokens
+func hookPath(hookURL string) string {
+	u, err := url.Parse(hookURL)
+	if err != nil {
+		return ""
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
+
+func (hs *HamalService) appendAudit(record *audit.Record) {
+	if hs.Audit == nil {
+		return
+	}
+	record.Time = time.Now().Format(time.RFC3339Nano)
+	if err := hs.Audit.Append(record); err != nil {
+		// the change is done, it can not be refused any more
+		log.Errorf("audit log error, %s of project %s app %s not recorded: %v",
+			record.Action, record.Project, record.AppId, err)
+	}
+}
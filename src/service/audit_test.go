@@ -0,0 +1,102 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/hamal/src/audit"
+	"github.com/Dataman-Cloud/hamal/src/fakeswan"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/swanclient"
+)
+
+// audited returns the records of SystemIdentity
+func (ts *testService) audited(t *testing.T) []*audit.Record {
+	records, err := ts.Audit.Query(audit.Filter{Identity: audit.SystemIdentity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestSwanChangesAudited(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	hooks := newHookServer(t, http.StatusOK)
+
+	policies := stages(models.TriggerManual, models.TriggerAuto)
+	policies[0].Hooks = &models.StageHooks{Post: []models.Hook{{Name: "smoke", URL: hooks.URL + "/smoke?token=secret"}}}
+	ts.create(t, "shop", "web", policies...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitState(t, "shop", "web", models.StateSucceeded)
+
+	records := ts.audited(t)
+	if len(records) != 3 {
+		t.Fatalf("%d records, want the update, the post hook and the proceed: %+v", len(records), records)
+	}
+	for n, want := range []struct{ action, reason string }{
+		{audit.ActionSwanUpdate, "rolling update"},
+		{audit.ActionPostHook, "hook smoke"},
+		{audit.ActionSwanProceedUpdate, "auto trigger"},
+	} {
+		r := records[n]
+		if r.Action != want.action || r.Reason != want.reason || r.Outcome != audit.OutcomeSuccess ||
+			r.Project != "shop" || r.AppId != "web" || r.Stage == nil || *r.Stage != int64(n/2) {
+			t.Errorf("record %d %+v, want %s for %s", n, r, want.action, want.reason)
+		}
+	}
+	if records[0].Status != http.StatusOK || records[0].Path != swanclient.Apps+"/web" {
+		t.Errorf("update recorded as %s %d, want the answer of swan", records[0].Path, records[0].Status)
+	}
+	if records[1].Path != hooks.URL+"/smoke" {
+		t.Errorf("hook recorded as %s, want its url without query", records[1].Path)
+	}
+}
+
+func TestAutoRollbackAudited(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	policies := stages(models.TriggerManual, models.TriggerManual)
+	policies[0].RollbackPolicy = models.AppRollbackPolicy{AutoRollback: true, RollbackCondition: 1}
+	ts.create(t, "shop", "web", policies...)
+	if err := ts.swan.SetFaults("web", fakeswan.Faults{FailTasks: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitState(t, "shop", "web", models.StateRolledBack)
+
+	records := ts.audited(t)
+	if len(records) != 2 {
+		t.Fatalf("%d records, want the update and the cancel: %+v", len(records), records)
+	}
+	r := records[1]
+	if r.Action != audit.ActionSwanCancelUpdate || !strings.HasPrefix(r.Reason, "auto rollback at stage 0") ||
+		r.Outcome != audit.OutcomeSuccess || r.Stage == nil || *r.Stage != 0 {
+		t.Errorf("rollback recorded as %+v", r)
+	}
+}
+
+func TestRefusedChangeAudited(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual)...)
+	if err := ts.swan.SetFaults("web", fakeswan.Faults{FailUpdates: 1, FailStatus: http.StatusConflict}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ts.RollingUpdate("shop", "web"); err == nil {
+		t.Fatal("rolling update passed a refused swan update")
+	}
+	records := ts.audited(t)
+	if len(records) != 1 || records[0].Outcome != audit.OutcomeFailure || records[0].Status != http.StatusConflict ||
+		records[0].Response == "" {
+		t.Errorf("refused update recorded as %+v, want the status and body of swan", records)
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Dataman-Cloud/hamal/src/config"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/swanclient"
+)
+
+// DefaultCluster names the cluster at SWAN_ADDR, it serves the apps which
+// name no cluster
+const DefaultCluster = "default"
+
+// newClusters returns a client per configured swan cluster
+func newClusters(c *config.Config) (map[string]*swanclient.Client, error) {
+	addrs := make(map[string]string)
+	if c.SwanAddr != "" {
+		addrs[DefaultCluster] = c.SwanAddr
+	}
+	for _, kv := range strings.Split(c.SwanClusters, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		nameAddr := strings.SplitN(kv, "=", 2)
+		if len(nameAddr) != 2 || nameAddr[0] == "" || nameAddr[1] == "" {
+			return nil, errors.New("invalid swan cluster " + kv)
+		}
+		if _, ok := addrs[nameAddr[0]]; ok {
+			return nil, errors.New("duplicated swan cluster " + nameAddr[0])
+		}
+		addrs[nameAddr[0]] = nameAddr[1]
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no swan cluster configured")
+	}
+
+	clusters := make(map[string]*swanclient.Client, len(addrs))
+	for name, addr := range addrs {
+		client, err := swanclient.New(addr, swanclient.WithTimeout(c.SwanTimeout))
+		if err != nil {
+			return nil, fmt.Errorf("invalid swan url %s of cluster %s", addr, name)
+		}
+		clusters[name] = client
+	}
+	return clusters, nil
+}
+
+// clusterName returns the cluster serving apps of the given cluster, the
+// only one configured or the default one when it is empty
+func (hs *HamalService) clusterName(cluster string) string {
+	if cluster != "" {
+		return cluster
+	}
+	if len(hs.Clusters) == 1 {
+		for name := range hs.Clusters {
+			return name
+		}
+	}
+	return DefaultCluster
+}
+
+// swan returns the client of the given cluster
+func (hs *HamalService) swan(cluster string) (*swanclient.Client, error) {
+	name := hs.clusterName(cluster)
+	client, ok := hs.Clusters[name]
+	if !ok {
+		return nil, errors.New("unknown swan cluster " + name)
+	}
+	return client, nil
+}
+
+// validateClusters checks the clusters the apps and the cluster order of
+// project refer to
+func (hs *HamalService) validateClusters(project *models.Project) error {
+	for _, application := range project.Applications {
+		if _, err := hs.swan(application.Cluster); err != nil {
+			return errors.New("app " + application.AppId + ": " + err.Error())
+		}
+	}
+	seen := make(map[string]bool)
+	for _, cluster := range project.ClusterOrder {
+		if seen[cluster] {
+			return errors.New("cluster " + cluster + " is ordered twice")
+		}
+		seen[cluster] = true
+		if _, ok := hs.Clusters[cluster]; !ok {
+			return errors.New("unknown swan cluster " + cluster)
+		}
+	}
+	return nil
+}
+
+// pendingClusters returns the clusters ordered before the one of application
+// which still have apps to update
+func (hs *HamalService) pendingClusters(project *models.Project, application models.AppUpdateStage) []string {
+	cluster := hs.clusterName(application.Cluster)
+	var pending []string
+	for _, previous := range project.ClusterOrder {
+		if previous == cluster {
+			return pending
+		}
+		for _, a := range project.Applications {
+			if hs.clusterName(a.Cluster) == previous && a.State != models.StateSucceeded {
+				pending = append(pending, previous)
+				break
+			}
+		}
+	}
+	// clusters out of the order wait for the whole order
+	return pending
+}
+
+// clusterProgress sums up the rollout of project per cluster
+func (hs *HamalService) clusterProgress(project *models.Project) []models.ClusterProgress {
+	progress := make(map[string]*models.ClusterProgress)
+	for _, application := range project.Applications {
+		name := hs.clusterName(application.Cluster)
+		p, ok := progress[name]
+		if !ok {
+			p = &models.ClusterProgress{Cluster: name}
+			progress[name] = p
+		}
+		p.Apps++
+		switch application.State {
+		case models.StateSucceeded:
+			p.Succeeded++
+		case models.StateFailed:
+			p.Failed++
+		case models.StateUpdating, models.StateVerifying, models.StatePaused:
+			p.Updating++
+		default:
+			if inFlight(application) {
+				p.Updating++
+			} else {
+				p.Pending++
+			}
+		}
+	}
+
+	names := make([]string, 0, len(progress))
+	for name := range progress {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]models.ClusterProgress, 0, len(names))
+	for _, name := range names {
+		p := progress[name]
+		switch {
+		case p.Failed > 0:
+			p.State = models.StateFailed
+		case p.Succeeded == p.Apps:
+			p.State = models.StateSucceeded
+		case p.Updating > 0 || p.Succeeded > 0:
+			p.State = models.StateUpdating
+		default:
+			p.State = models.StatePending
+		}
+		result = append(result, *p)
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/swanclient"
+)
+
+// twoClusters returns a service with the clusters east and west
+func twoClusters() *HamalService {
+	return &HamalService{Clusters: map[string]*swanclient.Client{"east": nil, "west": nil}}
+}
+
+func TestValidateClusters(t *testing.T) {
+	hs := twoClusters()
+	for _, project := range []*models.Project{
+		{Applications: []models.AppUpdateStage{{AppId: "web"}}},
+		{Applications: []models.AppUpdateStage{{AppId: "web", Cluster: "north"}}},
+		{ClusterOrder: []string{"east", "east"}},
+		{ClusterOrder: []string{"east", "north"}},
+	} {
+		if err := hs.validateClusters(project); err == nil {
+			t.Errorf("project of apps %+v ordering %q accepted", project.Applications, project.ClusterOrder)
+		}
+	}
+	project := &models.Project{
+		ClusterOrder: []string{"east", "west"},
+		Applications: []models.AppUpdateStage{{AppId: "web", Cluster: "west"}},
+	}
+	if err := hs.validateClusters(project); err != nil {
+		t.Error(err)
+	}
+
+	// the only cluster serves the apps which name none
+	single := &HamalService{Clusters: map[string]*swanclient.Client{"east": nil}}
+	if err := single.validateClusters(&models.Project{Applications: []models.AppUpdateStage{{AppId: "web"}}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClusterOrder(t *testing.T) {
+	hs := twoClusters()
+	project := &models.Project{
+		ClusterOrder: []string{"east", "west"},
+		Applications: []models.AppUpdateStage{
+			{AppId: "web-east", Cluster: "east", State: models.StateUpdating, StagesStarted: 1},
+			{AppId: "web-west", Cluster: "west", State: models.StatePending,
+				RollingUpdatePolicy: stages(models.TriggerManual)},
+		},
+	}
+
+	if _, blocked := hs.nextStage(project, project.Applications[1]); blocked != "waits for cluster east to be updated" {
+		t.Errorf("west app blocked by %q while east updates", blocked)
+	}
+	want := []models.ClusterProgress{
+		{Cluster: "east", State: models.StateUpdating, Apps: 1, Updating: 1},
+		{Cluster: "west", State: models.StatePending, Apps: 1, Pending: 1},
+	}
+	if progress := hs.clusterProgress(project); !reflect.DeepEqual(progress, want) {
+		t.Errorf("cluster progress %+v, want %+v", progress, want)
+	}
+
+	project.Applications[0].State = models.StateSucceeded
+	if stage, blocked := hs.nextStage(project, project.Applications[1]); stage != 0 {
+		t.Errorf("west app blocked by %q once east is updated", blocked)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// trackProgress records when one more task of the proposed version started
+// running, it returns whether application has been changed
+func trackProgress(application *models.AppUpdateStage, app types.App) bool {
+	if app.ProposedVersion == nil {
+		return false
+	}
+
+	var running int64
+	for _, task := range app.Tasks {
+		if task.VersionID != app.ProposedVersion.ID {
+			continue
+		}
+		state := task.Status
+		if task.CurrentTask != nil {
+			state = task.CurrentTask.State
+		}
+		if state == TaskRunning {
+			running++
+		}
+	}
+	if running <= application.UpdatedTasks {
+		return false
+	}
+	application.UpdatedTasks = running
+	application.ProgressAt = time.Now().Format(time.RFC3339Nano)
+	return true
+}
+
+// checkDeadlines fails application when its running stage outlived the
+// stage timeout or made no progress within the progress deadline of the
+// project, it returns whether application has been changed
+func (hs *HamalService) checkDeadlines(project *models.Project, application *models.AppUpdateStage) bool {
+	if application.State != models.StateUpdating && application.State != models.StateVerifying {
+		return false
+	}
+	stage := application.StagesStarted - 1
+	if stage < 0 || int(stage) >= len(application.RollingUpdatePolicy) {
+		return false
+	}
+
+	policy := application.RollingUpdatePolicy[stage]
+	timeout, err := models.ParseDeadline(policy.Timeout, policy.OnTimeout)
+	if err == nil && expired(application.StageStartedAt, timeout) {
+		hs.deadlineExpired(project, application, policy.OnTimeout,
+			fmt.Sprintf("stage %d timed out after %s", stage, timeout))
+		return true
+	}
+
+	deadline, err := models.ParseDeadline(project.ProgressDeadline, project.OnDeadline)
+	if err == nil && expired(application.ProgressAt, deadline) {
+		hs.deadlineExpired(project, application, project.OnDeadline,
+			fmt.Sprintf("stage %d made no progress for %s", stage, deadline))
+		return true
+	}
+	return false
+}
+
+// expired reports whether d has passed since the given time, a zero d never
+// expires
+func expired(since string, d time.Duration) bool {
+	if d <= 0 || since == "" {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339Nano, since)
+	return err == nil && time.Since(t) >= d
+}
+
+// deadlineExpired marks application failed and takes the configured action
+func (hs *HamalService) deadlineExpired(project *models.Project, application *models.AppUpdateStage, action, reason string) {
+	log.Warnf("project %s app %s: %s", project.Key(), application.AppId, reason)
+	setState(application, models.StateFailed, reason)
+
+	switch action {
+	case models.DeadlineRollback:
+		if err := hs.rollback(project, application.AppId, reason); err != nil {
+			log.Errorf("project %s app %s rollback error: %v", project.Key(), application.AppId, err)
+			setState(application, models.StateFailed, reason+", rollback error: "+err.Error())
+		}
+	case models.DeadlinePause:
+		// hold the other apps of the project until someone looks into it
+		project.Paused = true
+	case models.DeadlineNotify:
+		// the failure reaches the notification sinks like any other one
+		log.Errorf("project %s app %s failed: %s", project.Key(), application.AppId, reason)
+	}
+}
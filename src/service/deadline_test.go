@@ -0,0 +1,79 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/audit"
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func TestStageTimeoutRollsBack(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	// the tasks never start running
+	ts.swan.StartDelay = time.Hour
+	policies := stages(models.TriggerManual, models.TriggerManual)
+	policies[0].Timeout = "100ms"
+	policies[0].OnTimeout = models.DeadlineRollback
+	ts.create(t, "shop", "web", policies...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	application := ts.waitState(t, "shop", "web", models.StateRolledBack)
+	if !strings.Contains(application.Reason, "stage 0 timed out after 100ms") {
+		t.Errorf("rolled back for %q", application.Reason)
+	}
+	if ts.swanApp(t, "web").ProposedVersion != nil {
+		t.Error("update still in flight in swan after the timeout")
+	}
+	records := ts.audited(t)
+	if last := records[len(records)-1]; last.Action != audit.ActionSwanCancelUpdate || last.Reason != application.Reason {
+		t.Errorf("timeout rollback recorded as %+v", last)
+	}
+}
+
+func TestProgressDeadlinePausesProject(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.swan.StartDelay = time.Hour
+	project := newProject("shop", "web", stages(models.TriggerManual, models.TriggerAuto)...)
+	project.ProgressDeadline = "100ms"
+	project.OnDeadline = models.DeadlinePause
+	ts.createProject(t, project)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	application := ts.waitState(t, "shop", "web", models.StateFailed)
+	if !strings.Contains(application.Reason, "stage 0 made no progress for 100ms") {
+		t.Errorf("failed for %q", application.Reason)
+	}
+	stored, err := ts.Store.Get("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Paused {
+		t.Error("project not paused by its progress deadline")
+	}
+	// the update is left in swan for someone to look into
+	if ts.swanApp(t, "web").ProposedVersion == nil {
+		t.Error("update cancelled by a pause deadline")
+	}
+}
+
+func TestDeadlineNotReachedByProgress(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.swan.StartDelay = 20 * time.Millisecond
+	project := newProject("shop", "web", stages(models.TriggerManual, models.TriggerAuto)...)
+	project.ProgressDeadline = "1s"
+	ts.createProject(t, project)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitState(t, "shop", "web", models.StateSucceeded)
+}
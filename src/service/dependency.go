@@ -0,0 +1,67 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+// validateDependencies rejects unknown, duplicated and cyclic app dependencies
+func validateDependencies(project *models.Project) error {
+	apps := make(map[string]models.AppUpdateStage)
+	for _, app := range project.Applications {
+		if _, ok := apps[app.AppId]; ok {
+			return errors.New("app " + app.AppId + " is duplicated")
+		}
+		apps[app.AppId] = app
+	}
+	for _, app := range project.Applications {
+		for _, dep := range app.DependsOn {
+			if _, ok := apps[dep]; !ok {
+				return errors.New("app " + app.AppId + " depends on unknown app " + dep)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	var visit func(id string, path []string) error
+	visit = func(id string, path []string) error {
+		switch marks[id] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.New("cyclic app dependencies: " + strings.Join(append(path, id), " -> "))
+		}
+		marks[id] = visiting
+		for _, dep := range apps[id].DependsOn {
+			if err := visit(dep, append(path, id)); err != nil {
+				return err
+			}
+		}
+		marks[id] = visited
+		return nil
+	}
+	for _, app := range project.Applications {
+		if err := visit(app.AppId, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingDependencies returns the dependencies of application which have not
+// been fully rolled out yet
+func pendingDependencies(project *models.Project, application models.AppUpdateStage) []string {
+	var pending []string
+	for _, dep := range application.DependsOn {
+		if app := findApp(project, dep); app != nil && app.State != models.StateSucceeded {
+			pending = append(pending, dep)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// dependentApps returns a project rolling out a new command to the apps,
+// which depend on each other as given
+func dependentApps(deps map[string][]string, ids ...string) *models.Project {
+	project := &models.Project{Name: "shop"}
+	for _, id := range ids {
+		project.Applications = append(project.Applications, models.AppUpdateStage{
+			AppId:               id,
+			App:                 types.Version{AppID: id, RunAs: "test", Command: "sleep 200"},
+			RollingUpdatePolicy: stages(models.TriggerManual),
+			DependsOn:           deps[id],
+		})
+	}
+	return project
+}
+
+func TestValidateDependencies(t *testing.T) {
+	for _, tc := range []struct {
+		ids   []string
+		deps  map[string][]string
+		valid bool
+	}{
+		{[]string{"db", "api", "web"}, map[string][]string{"api": {"db"}, "web": {"api", "db"}}, true},
+		{[]string{"db", "db"}, nil, false},
+		{[]string{"api"}, map[string][]string{"api": {"db"}}, false},
+		{[]string{"api"}, map[string][]string{"api": {"api"}}, false},
+		{[]string{"db", "api", "web"}, map[string][]string{"db": {"web"}, "api": {"db"}, "web": {"api"}}, false},
+	} {
+		err := validateDependencies(dependentApps(tc.deps, tc.ids...))
+		if (err == nil) != tc.valid {
+			t.Errorf("apps %v depending on %v: %v, want valid %v", tc.ids, tc.deps, err, tc.valid)
+		}
+	}
+}
+
+func TestDependentAppWaits(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "db", 1)
+	ts.addApp(t, "api", 1)
+	ts.createProject(t, dependentApps(map[string][]string{"api": {"db"}}, "db", "api"))
+
+	if err := ts.RollingUpdate("shop", "api"); err == nil || !strings.Contains(err.Error(), "waits for db") {
+		t.Fatalf("api started before db is updated: %v", err)
+	}
+	if err := ts.RollingUpdate("shop", "db"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitState(t, "shop", "db", models.StateSucceeded)
+	if err := ts.RollingUpdate("shop", "api"); err != nil {
+		t.Fatalf("api refused once db is updated: %v", err)
+	}
+	ts.waitState(t, "shop", "api", models.StateSucceeded)
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// diffFields compares the json representation of two values field by field,
+// fields missing on one side are reported with a nil value
+func diffFields(old, new interface{}) ([]models.FieldDiff, error) {
+	oldFields, err := flatten(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flatten(new)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for name := range oldFields {
+		names[name] = true
+	}
+	for name := range newFields {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	diffs := []models.FieldDiff{}
+	for _, name := range sorted {
+		if !reflect.DeepEqual(oldFields[name], newFields[name]) {
+			diffs = append(diffs, models.FieldDiff{Field: name, Old: oldFields[name], New: newFields[name]})
+		}
+	}
+	return diffs, nil
+}
+
+// flatten maps every leaf of the json representation of v to its path
+func flatten(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	var walk func(prefix string, node interface{})
+	walk = func(prefix string, node interface{}) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			for k, v := range n {
+				if prefix == "" {
+					walk(k, v)
+				} else {
+					walk(prefix+"."+k, v)
+				}
+			}
+		case []interface{}:
+			for i, v := range n {
+				walk(prefix+"["+strconv.Itoa(i)+"]", v)
+			}
+		case nil:
+		default:
+			fields[prefix] = n
+		}
+	}
+	walk("", tree)
+	return fields, nil
+}
+
+// DiffVersions returns the structured diff between two versions of an app,
+// from defaults to the current version and to to the proposed one, or when
+// no update is in flight to the current version and its previous one
+func (hs *HamalService) DiffVersions(cluster, appId, from, to string) (*models.VersionDiff, error) {
+	app, err := hs.GetApp(cluster, appId)
+	if err != nil {
+		return nil, err
+	}
+	if from == "" || to == "" {
+		if app.CurrentVersion == nil {
+			return nil, errors.New("app " + appId + " has no current version")
+		}
+		switch {
+		case from != "":
+			to = app.CurrentVersion.ID
+		case to != "":
+			from = app.CurrentVersion.ID
+		case app.ProposedVersion != nil:
+			from, to = app.CurrentVersion.ID, app.ProposedVersion.ID
+		case app.CurrentVersion.PreviousVersionID != "":
+			from, to = app.CurrentVersion.PreviousVersionID, app.CurrentVersion.ID
+		default:
+			from, to = app.CurrentVersion.ID, app.CurrentVersion.ID
+		}
+	}
+	for _, id := range []string{from, to} {
+		if !hasVersion(app, id) {
+			return nil, errors.New("version " + id + " not exist in app " + appId)
+		}
+	}
+
+	fromVersion, err := hs.GetAppVersion(cluster, appId, from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := hs.GetAppVersion(cluster, appId, to)
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := diffFields(versionSpec(fromVersion), versionSpec(toVersion))
+	if err != nil {
+		return nil, err
+	}
+	if diffs, err = maskDiffs(diffs, fromVersion, toVersion); err != nil {
+		return nil, err
+	}
+
+	vd := &models.VersionDiff{AppId: appId, From: from, To: to}
+	for _, d := range diffs {
+		switch {
+		case d.Field == "container.docker.image" || d.Field == "container.docker.forcePullImage":
+			vd.Image = append(vd.Image, d)
+		case d.Field == "cmd" || strings.HasPrefix(d.Field, "args["):
+			vd.Command = append(vd.Command, d)
+		case d.Field == "cpus" || d.Field == "mem" || d.Field == "disk" || d.Field == "instances":
+			vd.Resources = append(vd.Resources, d)
+		case strings.HasPrefix(d.Field, "env."):
+			vd.Env = append(vd.Env, d)
+		case strings.HasPrefix(d.Field, "labels."):
+			vd.Labels = append(vd.Labels, d)
+		case strings.HasPrefix(d.Field, "healthChecks["):
+			vd.HealthChecks = append(vd.HealthChecks, d)
+		case strings.HasPrefix(d.Field, "constraints["):
+			vd.Constraints = append(vd.Constraints, d)
+		case strings.HasPrefix(d.Field, "container.docker.portMappings["):
+			vd.PortMappings = append(vd.PortMappings, d)
+		case strings.HasPrefix(d.Field, "container.volumes["):
+			vd.Volumes = append(vd.Volumes, d)
+		default:
+			vd.Other = append(vd.Other, d)
+		}
+	}
+	return vd, nil
+}
+
+// hasVersion reports whether versionId is one of the versions swan keeps
+// for app
+func hasVersion(app types.App, versionId string) bool {
+	for _, id := range app.Versions {
+		if id == versionId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffVersions(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	current := ts.swanApp(t, "web").CurrentVersion.ID
+
+	vd, err := ts.DiffVersions(testCluster, "web", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vd.From != current || vd.To != current || len(vd.Command) != 0 {
+		t.Errorf("diff of an app without update is %s -> %s %+v", vd.From, vd.To, vd.Command)
+	}
+
+	for _, id := range []string{"404", "../../web", "1?x=y"} {
+		if _, err := ts.DiffVersions(testCluster, "web", current, id); err == nil || !strings.Contains(err.Error(), "not exist in app web") {
+			t.Errorf("diff to version %q: %v, want it refused", id, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Dataman-Cloud/hamal/src/config"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/notify"
+	"github.com/Dataman-Cloud/hamal/src/store"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// globalSinks returns the sinks configured for every project
+func globalSinks(c *config.Config) []models.NotifySink {
+	var sinks []models.NotifySink
+	if c.NotifyWebhook != "" {
+		sinks = append(sinks, models.NotifySink{Type: models.SinkWebhook, URL: c.NotifyWebhook})
+	}
+	if c.NotifySlack != "" {
+		sinks = append(sinks, models.NotifySink{Type: models.SinkSlack, URL: c.NotifySlack})
+	}
+	if c.NotifyEmail != "" {
+		var to []string
+		for _, addr := range strings.Split(c.NotifyEmail, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				to = append(to, addr)
+			}
+		}
+		sinks = append(sinks, models.NotifySink{Type: models.SinkEmail, To: to})
+	}
+	return sinks
+}
+
+// validateNotifications checks the sinks of project, its name ends up in
+// the mail subjects so it holds no control character
+func validateNotifications(project *models.Project) error {
+	if strings.IndexFunc(project.Name, unicode.IsControl) >= 0 {
+		return fmt.Errorf("invalid project name %q", project.Name)
+	}
+	for _, sink := range project.Notifications {
+		if err := notify.ValidateSink(sink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runEvents turns the changes of the stored projects into rollout events.
+//
+// The events are derived from the state transitions recorded on the apps,
+// so every code path changing a rollout is covered without having to notify
+// by itself, and the handlers never wait for a delivery.
+func (hs *HamalService) runEvents(notifier *notify.Notifier) {
+	events, stop := hs.Store.Watch()
+	defer stop()
+
+	// what is stored already has been notified before a restart
+	seen := make(map[string]time.Time)
+	projects, err := hs.Store.List()
+	if err != nil {
+		log.Errorf("notifier list projects error: %v", err)
+	}
+	for _, project := range projects {
+		for _, application := range project.Applications {
+			seen[project.Key()+"/"+application.AppId] = lastTransition(application)
+		}
+		seen[project.Key()] = time.Time{}
+	}
+
+	for e := range events {
+		if e.Type == store.EventDelete {
+			for key := range seen {
+				if key == e.Name || strings.HasPrefix(key, e.Name+"/") {
+					delete(seen, key)
+				}
+			}
+			continue
+		}
+
+		project := e.Project
+		if _, ok := seen[project.Key()]; !ok {
+			seen[project.Key()] = time.Time{}
+			notifier.Notify(project.Notifications, &notify.Event{
+				Type:    notify.EventProjectCreated,
+				Project: project.Key(),
+				Time:    project.CreateTime,
+			})
+		}
+		for _, application := range project.Applications {
+			key := project.Key() + "/" + application.AppId
+			for _, t := range application.Transitions {
+				at, err := time.Parse(time.RFC3339Nano, t.Time)
+				if err != nil || !at.After(seen[key]) {
+					continue
+				}
+				seen[key] = at
+				for _, event := range transitionEvents(project, application, t) {
+					notifier.Notify(project.Notifications, event)
+				}
+			}
+		}
+	}
+}
+
+func lastTransition(application models.AppUpdateStage) time.Time {
+	var last time.Time
+	for _, t := range application.Transitions {
+		if at, err := time.Parse(time.RFC3339Nano, t.Time); err == nil && at.After(last) {
+			last = at
+		}
+	}
+	return last
+}
+
+// transitionEvents returns the rollout events a state transition stands for,
+// the stage of a transition is the number of stages started at that time
+func transitionEvents(project *models.Project, application models.AppUpdateStage, t models.Transition) []*notify.Event {
+	event := func(typ string, stage int64) *notify.Event {
+		return &notify.Event{
+			Type:    typ,
+			Project: project.Key(),
+			AppId:   application.AppId,
+			Stage:   stage,
+			Reason:  t.Reason,
+			Time:    t.Time,
+		}
+	}
+
+	var events []*notify.Event
+	switch t.To {
+	case models.StateUpdating:
+		// a paused stage moving on is not started again
+		if t.From != models.StateUpdating && t.From != models.StatePaused {
+			events = append(events, event(notify.EventStageStarted, t.Stage-1))
+		}
+	case models.StatePending, models.StateSucceeded:
+		if t.From == models.StateUpdating || t.From == models.StateVerifying {
+			events = append(events, event(notify.EventStageCompleted, t.Stage-1))
+		}
+	case models.StateRolledBack, models.StateFailed:
+		// the stage in flight when the rollout stopped
+		stage := t.Stage - 1
+		if stage < 0 {
+			stage = 0
+		}
+		typ := notify.EventFailure
+		if t.To == models.StateRolledBack {
+			typ = notify.EventRollback
+		}
+		events = append(events, event(typ, stage))
+	}
+
+	if t.To == models.StatePending && int(t.Stage) < len(application.RollingUpdatePolicy) {
+		if pending := approvalPending(application, t.Stage); pending != "" {
+			e := event(notify.EventApprovalNeeded, t.Stage)
+			e.Reason = pending
+			events = append(events, e)
+		}
+	}
+	return events
+}
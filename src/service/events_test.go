@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/notify"
+)
+
+func TestRollbackEventHasStageInFlight(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 3)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual, models.TriggerManual)...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitStage(t, "shop", "web", 1)
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Rollback("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := ts.Store.Get("shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	application := project.Applications[0]
+	last := application.Transitions[len(application.Transitions)-1]
+	events := transitionEvents(project, application, last)
+	if len(events) != 1 || events[0].Type != notify.EventRollback || events[0].Stage != 1 {
+		t.Fatalf("rollback notified as %+v, want a rollback of stage 1", events)
+	}
+	if application.StagesStarted != 0 || application.NextStage != 0 {
+		t.Errorf("app at stage %d, next %d after the rollback", application.StagesStarted, application.NextStage)
+	}
+}
+
+func TestValidateNotifications(t *testing.T) {
+	for _, project := range []*models.Project{
+		{Name: "shop\r\nBcc: x@example.com"},
+		{Name: "shop", Notifications: []models.NotifySink{{Type: models.SinkEmail, To: []string{"ops@example.com\r\nBcc: x@example.com"}}}},
+	} {
+		if err := validateNotifications(project); err == nil {
+			t.Errorf("project %q notifying %+v accepted", project.Name, project.Notifications)
+		}
+	}
+	project := &models.Project{Name: "shop", Notifications: []models.NotifySink{{Type: models.SinkEmail, To: []string{"Ops <ops@example.com>"}}}}
+	if err := validateNotifications(project); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,264 +1,464 @@
 package service
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
 	"sync"
 	"time"
 
+	"github.com/Dataman-Cloud/hamal/src/audit"
 	"github.com/Dataman-Cloud/hamal/src/config"
 	"github.com/Dataman-Cloud/hamal/src/models"
-	"github.com/Dataman-Cloud/hamal/src/utils"
+	"github.com/Dataman-Cloud/hamal/src/notify"
+	"github.com/Dataman-Cloud/hamal/src/store"
+	"github.com/Dataman-Cloud/hamal/src/swanclient"
 	"github.com/Dataman-Cloud/swan/src/types"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 const (
-	Apps          = "/v_beta/apps"
-	ProceedUpdate = "/proceed-update"
-)
-
-const (
-	DeploySuccess = "success"
-	DeployCreated = "created"
-	DeployIng     = "updateing"
-	Undefined     = "undefined"
+	DeploySuccess   = "success"
+	DeployCreated   = "created"
+	DeployIng       = "updateing"
+	DeployVerifying = "verifying"
+	DeployFailed    = "failed"
+	DeployPaused    = "paused"
+	Undefined       = "undefined"
 )
 
 type HamalService struct {
-	SwanHost     string
-	Projects     map[string]*models.Project
-	CurrentStage map[string]int64
-	Client       *http.Client
-	PMutex       *sync.Mutex
+	// Clusters holds a swan client per cluster name
+	Clusters map[string]*swanclient.Client
+	Store    store.ProjectStore
+	// PMutex serializes the creates, updates and deletes of projects so the
+	// namespace quotas hold, it is taken before the lock of a project
+	PMutex *sync.Mutex
+	// HealthGracePeriod is how long the updated tasks of a stage must stay
+	// running and healthy before the stage is done
+	HealthGracePeriod time.Duration
+	Notifier          *notify.Notifier
+	// Audit records the changes hamal makes in swan and the hooks it calls,
+	// nothing is recorded when it is nil
+	Audit audit.Log
+
+	// locks serialize the changes of each project, swan is called for
+	// the reconciliation before the project is locked
+	locks projectLocks
+	// hookRuns holds the hook calls in flight
+	hookRuns hookRuns
+
+	// namespaces are declared by the namespaces file, any namespace may be
+	// used without quota when it is nil
+	namespaces *namespaceFile
+
+	tasks taskFeed
+	// kicks carries the apps to reconcile right away, streaming counts
+	// the connected swan event streams
+	kicks     chan string
+	streaming int32
 }
 
 func InitHamalService() *HamalService {
-	u, err := url.Parse(config.GetConfig().SwanAddr)
+	clusters, err := newClusters(config.GetConfig())
 	if err != nil {
-		log.Fatalf("invalid swan url: %s", config.GetConfig().SwanAddr)
+		log.Fatalf("swan clusters error: %v", err)
 		return nil
 	}
-	return &HamalService{
-		SwanHost:     u.String(),
-		Projects:     make(map[string]*models.Project),
-		CurrentStage: make(map[string]int64),
-		Client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-		PMutex: new(sync.Mutex),
+	s, err := store.NewFileStore(config.GetConfig().StorePath)
+	if err != nil {
+		log.Fatalf("open project store error: %v", err)
+		return nil
+	}
+	sinks := globalSinks(config.GetConfig())
+	for _, sink := range sinks {
+		if err := notify.ValidateSink(sink); err != nil {
+			log.Fatalf("notification sink error: %v", err)
+			return nil
+		}
+	}
+	hs := &HamalService{
+		Clusters:          clusters,
+		Store:             s,
+		PMutex:            new(sync.Mutex),
+		kicks:             make(chan string, 64),
+		HealthGracePeriod: config.GetConfig().HealthGracePeriod,
+		Notifier: notify.NewNotifier(sinks, notify.SMTPConfig{
+			Addr:     config.GetConfig().SMTPAddr,
+			From:     config.GetConfig().SMTPFrom,
+			Username: config.GetConfig().SMTPUsername,
+			Password: config.GetConfig().SMTPPassword,
+		}),
+	}
+	if hs.HealthGracePeriod <= 0 {
+		hs.HealthGracePeriod = DefaultHealthGracePeriod
 	}
+	if path := config.GetConfig().NamespacesFile; path != "" {
+		if hs.namespaces, err = newNamespaceFile(path); err != nil {
+			log.Fatalf("load namespaces file error: %v", err)
+			return nil
+		}
+	}
+	go hs.runEvents(hs.Notifier)
+	for name := range hs.Clusters {
+		go hs.runSwanEvents(name)
+	}
+	go hs.runReconciler(config.GetConfig().ReconcileInterval, config.GetConfig().SwanPollInterval)
+	return hs
 }
 
 func (hs *HamalService) CreateOrUpdateProject(project *models.Project) error {
 	hs.PMutex.Lock()
 	defer hs.PMutex.Unlock()
-	if _, ok := hs.Projects[project.Name]; ok {
+	if err := hs.validateNewProject(project); err != nil {
+		return err
+	}
+	unlock := hs.locks.lock(project.Key())
+	defer unlock()
+
+	project.CreateTime = time.Now().Format(time.RFC3339Nano)
+	project.Status = 0
+	// the cluster progress is derived when the project is read
+	project.Clusters = nil
+	for n := range project.Applications {
+		newRollout(&project.Applications[n])
+	}
+	return hs.Store.Put(project)
+}
+
+// validateNewProject checks a project before it is created
+func (hs *HamalService) validateNewProject(project *models.Project) error {
+	if err := project.ValidateName(); err != nil {
+		return err
+	}
+	if project.Namespace == "" {
+		project.Namespace = models.DefaultNamespace
+	}
+	if _, err := hs.Store.Get(project.Key()); err == nil {
 		return errors.New("project is exist")
+	} else if err != store.ErrNotExist {
+		return err
+	}
+	if err := hs.checkQuota(project); err != nil {
+		return err
+	}
+	if err := validateProject(project); err != nil {
+		return err
+	}
+	if err := hs.validateClusters(project); err != nil {
+		return err
 	}
 	for _, app := range project.Applications {
-		as, err := hs.GetApp(app.AppId)
+		as, err := hs.GetApp(app.Cluster, app.AppId)
 		if err != nil {
 			return err
 		}
 		if as.State != "normal" {
 			return errors.New("app state is't normal can't update")
 		}
+		if app.Cluster != "" && as.ClusterID != "" && as.ClusterID != app.Cluster {
+			return errors.New("app " + app.AppId + " runs in cluster " + as.ClusterID + " not " + app.Cluster)
+		}
+		if err := validateCoverage(app, as); err != nil {
+			return err
+		}
 	}
-
-	project.CreateTime = time.Now().Format(time.RFC3339Nano)
-	hs.Projects[project.Name] = project
 	return nil
 }
 
 func (hs *HamalService) UpdateProject(project *models.Project) error {
 	hs.PMutex.Lock()
 	defer hs.PMutex.Unlock()
-	if _, ok := hs.Projects[project.Name]; !ok {
-		return errors.New("project " + project.Name + " is not exist")
+	if project.Namespace == "" {
+		project.Namespace = models.DefaultNamespace
+	}
+	unlock := hs.locks.lock(project.Key())
+	defer unlock()
+	old, err := hs.Store.Get(project.Key())
+	if err == store.ErrNotExist {
+		return errors.New("project " + project.Key() + " is not exist")
+	} else if err != nil {
+		return err
+	}
+	if err := hs.checkQuota(project); err != nil {
+		return err
+	}
+	if err := validateProject(project); err != nil {
+		return err
+	}
+	if err := hs.validateClusters(project); err != nil {
+		return err
 	}
 
 	project.CreateTime = time.Now().Format(time.RFC3339Nano)
-	hs.Projects[project.Name] = project
+	project.Status = old.Status
+	project.Clusters = nil
+	project.Paused = old.Paused
+	for n := range project.Applications {
+		application := &project.Applications[n]
+		previous := findApp(old, application.AppId)
+		if previous != nil && inFlight(*previous) {
+			// keep the bookkeeping of the rollouts in flight
+			application.Cluster = previous.Cluster
+			application.State = previous.State
+			application.Reason = previous.Reason
+			application.Transitions = previous.Transitions
+			application.StagesStarted = previous.StagesStarted
+			application.StageInstances = previous.StageInstances
+			application.Approvals = previous.Approvals
+			application.Paused = previous.Paused
+			application.StageStartedAt = previous.StageStartedAt
+			application.StageCompletedAt = previous.StageCompletedAt
+			application.UpdatedTasks = previous.UpdatedTasks
+			application.ProgressAt = previous.ProgressAt
+			application.HealthySince = previous.HealthySince
+			application.PostHooksRun = previous.PostHooksRun
+			application.HookResults = previous.HookResults
+			updateStatus(application)
+			continue
+		}
+		newRollout(application)
+	}
+	// the stages must cover the live app as on create
+	for _, application := range project.Applications {
+		app, err := hs.GetApp(application.Cluster, application.AppId)
+		if err != nil {
+			return err
+		}
+		if err := validateCoverage(application, app); err != nil {
+			return err
+		}
+	}
+	return hs.Store.Put(project)
+}
+
+func validateProject(project *models.Project) error {
+	if err := validateDependencies(project); err != nil {
+		return err
+	}
+	if _, err := models.ParseDeadline(project.ProgressDeadline, project.OnDeadline); err != nil {
+		return err
+	}
+	if err := validateNotifications(project); err != nil {
+		return err
+	}
+	for _, app := range project.Applications {
+		if err := validatePolicy(app); err != nil {
+			return err
+		}
+		if err := validateApprovals(app); err != nil {
+			return err
+		}
+		if err := validateHooks(app); err != nil {
+			return err
+		}
+		for _, policy := range app.RollingUpdatePolicy {
+			if _, _, err := policy.ParseTrigger(); err != nil {
+				return errors.New("app " + app.AppId + ": " + err.Error())
+			}
+			if _, err := models.ParseDeadline(policy.Timeout, policy.OnTimeout); err != nil {
+				return errors.New("app " + app.AppId + ": " + err.Error())
+			}
+		}
+	}
 	return nil
 }
 
-func (hs *HamalService) GetProjects() []*models.Project {
-	hs.PMutex.Lock()
-	defer hs.PMutex.Unlock()
-	var projects []*models.Project
-	for _, v := range hs.Projects {
-		hs.GetProjectDeployStatus(v)
-		projects = append(projects, v)
+// findApp returns the app of project with the given id
+func findApp(project *models.Project, appId string) *models.AppUpdateStage {
+	for n := range project.Applications {
+		if project.Applications[n].AppId == appId {
+			return &project.Applications[n]
+		}
 	}
-	return projects
+	return nil
+}
+
+func (hs *HamalService) GetProjects() ([]*models.Project, error) {
+	projects, err := hs.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, project := range projects {
+		project.Clusters = hs.clusterProgress(project)
+		if project.Namespace == "" {
+			project.Namespace = models.DefaultNamespace
+		}
+		maskProject(project)
+	}
+	return projects, nil
 }
 
 func (hs *HamalService) DeleteProject(name string) error {
 	hs.PMutex.Lock()
 	defer hs.PMutex.Unlock()
-	if _, ok := hs.Projects[name]; !ok {
+	unlock := hs.locks.lock(name)
+	defer unlock()
+	if err := hs.Store.Delete(name); err == store.ErrNotExist {
 		return errors.New("project " + name + " is not exist")
+	} else if err != nil {
+		return err
 	}
-
-	delete(hs.Projects, name)
 	return nil
 }
 
 func (hs *HamalService) GetProject(name string) (*models.Project, error) {
-	hs.PMutex.Lock()
-	defer hs.PMutex.Unlock()
-	project, ok := hs.Projects[name]
-	if !ok {
+	project, err := hs.Store.Get(name)
+	if err == store.ErrNotExist {
 		return project, errors.New("project " + name + " is not exist")
+	} else if err != nil {
+		return project, err
 	}
-
-	hs.GetProjectDeployStatus(project)
-	return project, nil
+	project.Clusters = hs.clusterProgress(project)
+	if project.Namespace == "" {
+		project.Namespace = models.DefaultNamespace
+	}
+	return maskProject(project), nil
 }
 
-func (hs *HamalService) GetProjectDeployStatus(project *models.Project) {
-	for n, application := range project.Applications {
-		status, stage := hs.GetAppDeployStatus(project, application)
-		project.Applications[n].NextStage = stage
-		project.Applications[n].Status = status
+// ProjectLabels returns the labels of a project, they select the roles the
+// callers have on it
+func (hs *HamalService) ProjectLabels(name string) (map[string]string, error) {
+	project, err := hs.Store.Get(name)
+	if err == store.ErrNotExist {
+		return nil, errors.New("project " + name + " is not exist")
+	} else if err != nil {
+		return nil, err
 	}
-
+	return project.Labels, nil
 }
 
-func (hs *HamalService) GetAppDeployStatus(project *models.Project, application models.AppUpdateStage) (string, int64) {
-	app, err := hs.GetApp(application.AppId)
+// AppProjects returns the projects rolling out the app
+func (hs *HamalService) AppProjects(appId string) ([]*models.Project, error) {
+	projects, err := hs.Store.List()
 	if err != nil {
-		return Undefined, 0
+		return nil, err
 	}
-
-	if app.ProposedVersion == nil {
-		if project.Status == 0 {
-			return DeployCreated, int64(0)
+	var result []*models.Project
+	for _, project := range projects {
+		if findApp(project, appId) != nil {
+			result = append(result, project)
 		}
-		return DeploySuccess, int64(0)
 	}
+	return result, nil
+}
 
-	var appCurrentVersion int64
-	for _, task := range app.Tasks {
-		if app.ProposedVersion != nil && task.VersionID == app.ProposedVersion.ID {
-			appCurrentVersion += 1
-		}
+func (hs *HamalService) RollingUpdate(projectName, appName string) error {
+	project, err := hs.Store.Get(projectName)
+	if err == store.ErrNotExist {
+		return errors.New("project " + projectName + " not exist")
+	} else if err != nil {
+		return err
 	}
+	// observe swan right away, the stored state may be one interval old
+	_, err = hs.changeProject(project, nil, func(project *models.Project, apps swanApps, hooks *hookCalls) (bool, error) {
+		application := findApp(project, appName)
+		if application == nil {
+			return false, errors.New("invalid stage")
+		}
+		changed := hs.reconcileProject(project, apps, nil, hooks)
 
-	var stageCount int64
-	for stageNum, rp := range application.RollingUpdatePolicy {
-		stageCount += rp.InstancesToUpdate
-		/*if appCurrentVersion == 1 {
-			return app.State, int64(0)
-		} else if appCurrentVersion-1 == stageCount {
-			return app.State, int64(stageNum + 1)
-		}*/
-
-		if appCurrentVersion == stageCount {
-			return app.State, int64(stageNum + 1)
+		stage, blocked := hs.nextStage(project, *application)
+		if stage < 0 {
+			return changed, errors.New("app " + appName + ": " + blocked)
 		}
-	}
 
-	return Undefined, 0
+		project.Status = 1
+		// the hooks called before a refused stage are recorded as well
+		return true, hs.startStage(project, application, stage, "rolling update", hooks)
+	})
+	return err
 }
 
-func (hs *HamalService) RollingUpdate(projectName, appName string) error {
-	hs.PMutex.Lock()
-	defer hs.PMutex.Unlock()
-
-	project, ok := hs.Projects[projectName]
-	if !ok {
-		return errors.New("project " + projectName + " not exist")
+// startStage asks swan to update the instances of the given stage for the
+// given reason, the first stage submits the new version, the following ones
+// proceed the update. It returns errHooksPending until the pre hooks of the
+// stage are called.
+func (hs *HamalService) startStage(project *models.Project, application *models.AppUpdateStage, stage int64, reason string, hooks *hookCalls) error {
+	if reason := pauseReason(project, *application); reason != "" {
+		return errors.New("app " + application.AppId + " is " + reason)
 	}
 
-	var application models.AppUpdateStage
-	instance := int64(0)
-	for _, app := range project.Applications {
-		state, stage := hs.GetAppDeployStatus(project, app)
-		if app.AppId == appName && int(stage) < len(app.RollingUpdatePolicy) && state != DeploySuccess {
-			instance = app.RollingUpdatePolicy[stage].InstancesToUpdate
-			application = app
-			break
-		}
+	swan, err := hs.swan(application.Cluster)
+	if err != nil {
+		return err
+	}
+	app, err := swan.GetApp(context.Background(), application.AppId)
+	if err != nil {
+		return err
 	}
 
-	if instance == 0 {
+	instance := stageInstances(*application, int(stage), int64(app.Instances))
+	if instance <= 0 {
 		return errors.New("invalid stage")
 	}
-
-	app, err := hs.GetApp(appName)
-	if err != nil {
+	policy := application.RollingUpdatePolicy[stage]
+	if unavailable := unavailableTasks(app); policy.MaxUnavailable > 0 && unavailable+instance > policy.MaxUnavailable {
+		return fmt.Errorf("stage %d would leave %d instances unavailable, max_unavailable is %d",
+			stage, unavailable+instance, policy.MaxUnavailable)
+	}
+	if err := hs.runHooks(project, application, stage, models.HookPre, hooks); err != nil {
 		return err
 	}
-	project.Status = 1
-	log.Info(hs.SwanHost + Apps + "/" + application.AppId)
-	if app.State == "normal" && app.ProposedVersion == nil {
-		body, _ := json.Marshal(application.App)
-		req, err := http.NewRequest("PUT",
-			hs.SwanHost+Apps+"/"+application.AppId,
-			bytes.NewReader(body))
-		req.Header.Add("Content-Type", "application/json")
-		if err != nil {
-			log.Error(err)
-			return err
+	stageStarted := func() {
+		counts := application.StageInstances
+		if len(counts) > int(stage) {
+			counts = counts[:stage]
 		}
+		for n := len(counts); n < int(stage); n++ {
+			counts = append(counts, stageInstances(*application, n, int64(app.Instances)))
+		}
+		application.StageInstances = append(counts, instance)
+		application.StagesStarted = stage + 1
+		application.HealthySince = ""
+		application.StageStartedAt = time.Now().Format(time.RFC3339Nano)
+		application.StageCompletedAt = ""
+		application.ProgressAt = application.StageStartedAt
+		setState(application, models.StateUpdating, "")
+	}
 
-		resp, err := hs.Client.Do(req)
+	if app.State == "normal" && app.ProposedVersion == nil {
+		reply, err := swan.UpdateApp(context.Background(), application.AppId, application.App)
+		hs.auditSwan(project, application.AppId, stage, audit.ActionSwanUpdate, reason, reply, err)
 		if err != nil {
 			log.Error(err)
 			return err
 		}
-
-		if resp.StatusCode != http.StatusOK {
-			data, _ := utils.ReadResponseBody(resp)
-			log.Errorf("%s", data)
-			return errors.New(string(data))
-		}
+		stageStarted()
 		return nil
 	}
 
-	req, err := http.NewRequest("PATCH",
-		fmt.Sprintf("%s%s/%s%s", hs.SwanHost, Apps, appName, ProceedUpdate),
-		bytes.NewReader([]byte(fmt.Sprintf("{\"instances\": %d}", instance))))
-
+	reply, err := swan.ProceedUpdate(context.Background(), application.AppId, instance)
+	hs.auditSwan(project, application.AppId, stage, audit.ActionSwanProceedUpdate, reason, reply, err)
 	if err != nil {
+		log.Error(err)
 		return err
 	}
-	req.Header.Add("Content-Type", "application/json")
+	stageStarted()
+	return nil
+}
 
-	resp, err := hs.Client.Do(req)
+func (hs *HamalService) GetApp(cluster, id string) (types.App, error) {
+	swan, err := hs.swan(cluster)
 	if err != nil {
-		return err
-	}
-	if resp.StatusCode != http.StatusOK {
-		data, _ := utils.ReadResponseBody(resp)
-		return errors.New(string(data))
+		return types.App{}, err
 	}
-
-	return nil
+	return swan.GetApp(context.Background(), id)
 }
 
-func (hs *HamalService) GetApp(id string) (types.App, error) {
-	var app types.App
-	resp, err := hs.Client.Get(hs.SwanHost + Apps + "/" + id)
+func (hs *HamalService) GetAppVersion(cluster, appId, versionId string) (types.Version, error) {
+	swan, err := hs.swan(cluster)
 	if err != nil {
-		return app, err
+		return types.Version{}, err
 	}
-	data, _ := utils.ReadResponseBody(resp)
-	err = json.Unmarshal(data, &app)
-	return app, err
+	return swan.GetVersion(context.Background(), appId, versionId)
 }
 
-func (hs *HamalService) GetAppVersions(appId string) (map[string]types.Version, error) {
+func (hs *HamalService) GetAppVersions(cluster, appId string) (map[string]types.Version, error) {
 	m := make(map[string]types.Version)
 
-	app, err := hs.GetApp(appId)
+	app, err := hs.GetApp(cluster, appId)
 	if err != nil {
 		return m, err
 	}
@@ -277,46 +477,54 @@ func (hs *HamalService) GetAppVersions(appId string) (map[string]types.Version,
 		}
 	}
 
-	resp, err := hs.Client.Get(fmt.Sprintf("%s%s/%s/versions/%s", hs.SwanHost, Apps, appId, newVersionId))
+	newVersion, err := hs.GetAppVersion(cluster, appId, newVersionId)
 	if err == nil {
-		var newVersion types.Version
-		data, _ := utils.ReadResponseBody(resp)
-		json.Unmarshal(data, &newVersion)
-		m["new_version"] = newVersion
+		m["new_version"] = maskVersion(newVersion)
 	}
 
 	if oldVersionId != "" {
-		m["old_version"] = *app.ProposedVersion
+		m["old_version"] = maskVersion(*app.ProposedVersion)
 	}
 
 	return m, err
 }
 
 func (hs *HamalService) Rollback(projectName, appId string) error {
-	hs.PMutex.Lock()
-	defer hs.PMutex.Unlock()
+	_, err := hs.updateProject(projectName, func(project *models.Project) (bool, error) {
+		if err := hs.rollback(project, appId, "manual rollback"); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	return err
+}
 
-	_, ok := hs.Projects[projectName]
-	if !ok {
-		return errors.New("project " + projectName + " not exist")
+// rollback cancels the in-flight update of the app and records the reason,
+// the caller is responsible for saving the project
+func (hs *HamalService) rollback(project *models.Project, appId, reason string) error {
+	application := findApp(project, appId)
+	if application == nil {
+		return errors.New("app " + appId + " not exist in project " + project.Key())
 	}
-
-	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s%s/%s/cancel-update", hs.SwanHost, Apps, appId), nil)
+	swan, err := hs.swan(application.Cluster)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := hs.Client.Do(req)
+	reply, err := swan.CancelUpdate(context.Background(), appId)
+	hs.auditSwan(project, appId, application.StagesStarted-1, audit.ActionSwanCancelUpdate, reason, reply, err)
 	if err != nil {
+		log.Error(err)
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		data, _ := utils.ReadResponseBody(resp)
-		log.Error(string(data))
-		return errors.New(string(data))
+	// the project stays started while another app is rolling out
+	project.Status = 0
+	for n := range project.Applications {
+		if project.Applications[n].AppId == appId {
+			rollBack(&project.Applications[n], reason)
+		} else if project.Applications[n].StagesStarted > 0 {
+			project.Status = 1
+		}
 	}
-	hs.Projects[projectName].Status = 0
 	return nil
 }
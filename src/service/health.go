@@ -0,0 +1,83 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// DefaultHealthGracePeriod is used when no health grace period is configured
+const DefaultHealthGracePeriod = 30 * time.Second
+
+// stageHealthy reports whether the updated tasks of the current stage have
+// been running and healthy for the whole grace period
+func (hs *HamalService) stageHealthy(application models.AppUpdateStage, app types.App) bool {
+	if application.HealthySince == "" || !versionHealthy(app, app.ProposedVersion) {
+		return false
+	}
+	since, err := time.Parse(time.RFC3339Nano, application.HealthySince)
+	return err == nil && time.Since(since) >= hs.HealthGracePeriod
+}
+
+// verifyHealth records when the updated tasks of the started stages became
+// healthy, it returns whether application has been changed
+func (hs *HamalService) verifyHealth(application *models.AppUpdateStage, app types.App) bool {
+	healthy := false
+	if app.ProposedVersion != nil && int(application.StagesStarted) <= len(application.RollingUpdatePolicy) {
+		var target int64
+		for n := 0; n < int(application.StagesStarted); n++ {
+			target += stageInstances(*application, n, int64(app.Instances))
+		}
+		healthy = target > 0 && versionTasks(app, app.ProposedVersion) == target &&
+			versionHealthy(app, app.ProposedVersion)
+	}
+
+	if healthy && application.HealthySince == "" {
+		application.HealthySince = time.Now().Format(time.RFC3339Nano)
+		return true
+	}
+	if !healthy && application.HealthySince != "" {
+		application.HealthySince = ""
+		return true
+	}
+	return false
+}
+
+func versionTasks(app types.App, version *types.Version) int64 {
+	var count int64
+	for _, task := range app.Tasks {
+		if task.VersionID == version.ID {
+			count++
+		}
+	}
+	return count
+}
+
+// versionHealthy reports whether every task of version is running and, when
+// the version defines health checks, healthy
+func versionHealthy(app types.App, version *types.Version) bool {
+	if version == nil {
+		return false
+	}
+
+	count := 0
+	for _, task := range app.Tasks {
+		if task.VersionID != version.ID {
+			continue
+		}
+		count++
+
+		state := task.Status
+		if task.CurrentTask != nil {
+			state = task.CurrentTask.State
+		}
+		if state != TaskRunning {
+			return false
+		}
+		if len(version.HealthChecks) > 0 && !task.Healthy {
+			return false
+		}
+	}
+	return count > 0
+}
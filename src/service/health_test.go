@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/fakeswan"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+func TestUnhealthyStageHoldsTheNext(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	project := newProject("shop", "web", stages(models.TriggerManual, models.TriggerAuto)...)
+	project.Applications[0].App.HealthChecks = []*types.HealthCheck{{Protocol: "http", PortName: "web", Path: "/health"}}
+	ts.createProject(t, project)
+	if err := ts.swan.SetFaults("web", fakeswan.Faults{UnhealthyTasks: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitState(t, "shop", "web", models.StateVerifying)
+	application := ts.hold(t, "shop", "web", 10*ts.HealthGracePeriod)
+	if application.State != models.StateVerifying || application.HealthySince != "" {
+		t.Errorf("app with an unhealthy task is %s since %q, want %s", application.State, application.HealthySince, models.StateVerifying)
+	}
+}
+
+func TestHealthyStageWaitsGracePeriod(t *testing.T) {
+	ts := newTestService(t)
+	ts.HealthGracePeriod = 200 * time.Millisecond
+	ts.addApp(t, "web", 2)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual)...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	application := ts.waitFor(t, "shop", "web", "healthy", func(application models.AppUpdateStage) bool {
+		return application.HealthySince != ""
+	})
+	if application.State != models.StateVerifying {
+		t.Errorf("app is %s as soon as it is healthy, want %s", application.State, models.StateVerifying)
+	}
+
+	application = ts.waitStage(t, "shop", "web", 1)
+	healthy, _ := time.Parse(time.RFC3339Nano, application.HealthySince)
+	completed, _ := time.Parse(time.RFC3339Nano, application.StageCompletedAt)
+	if completed.Sub(healthy) < ts.HealthGracePeriod {
+		t.Errorf("stage done %s after it was healthy, want %s", completed.Sub(healthy), ts.HealthGracePeriod)
+	}
+	if application.State != models.StatePending {
+		t.Errorf("app is %s once the stage is healthy, want %s", application.State, models.StatePending)
+	}
+}
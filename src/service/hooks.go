@@ -0,0 +1,386 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultHookTimeout bounds a hook call which sets no timeout
+	DefaultHookTimeout = 10 * time.Second
+	// HookRetryDelay is waited between the attempts of a hook
+	HookRetryDelay = time.Second
+	// MaxHookOutput is how much of a hook response is recorded
+	MaxHookOutput = 4096
+	// MaxHookResults is how many hook results are kept per app
+	MaxHookResults = 100
+)
+
+// hookPayload is posted to hooks which define no body
+type hookPayload struct {
+	Project string `json:"project"`
+	AppId   string `json:"app_id"`
+	Stage   int64  `json:"stage"`
+	Phase   string `json:"phase"`
+}
+
+// validateHooks checks the hooks of every stage of application
+func validateHooks(application models.AppUpdateStage) error {
+	for n, policy := range application.RollingUpdatePolicy {
+		if policy.Hooks == nil {
+			continue
+		}
+		for _, hook := range append(policy.Hooks.Pre, policy.Hooks.Post...) {
+			if err := validateHook(hook); err != nil {
+				return fmt.Errorf("app %s stage %d: %v", application.AppId, n, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateHook(hook models.Hook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid hook url " + hook.URL)
+	}
+	switch hook.Method {
+	case "", "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		return errors.New("invalid hook method " + hook.Method)
+	}
+	if hook.Timeout != "" {
+		if d, err := time.ParseDuration(hook.Timeout); err != nil || d <= 0 {
+			return errors.New("invalid hook timeout " + hook.Timeout)
+		}
+	}
+	if hook.Retries < 0 {
+		return errors.New("negative hook retries")
+	}
+	for _, status := range hook.ExpectStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid hook expect_status %d", status)
+		}
+	}
+	return nil
+}
+
+// stageHooks returns the hooks of the given phase of a stage
+func stageHooks(application models.AppUpdateStage, stage int64, phase string) []models.Hook {
+	if stage < 0 || int(stage) >= len(application.RollingUpdatePolicy) {
+		return nil
+	}
+	hooks := application.RollingUpdatePolicy[stage].Hooks
+	if hooks == nil {
+		return nil
+	}
+	if phase == models.HookPre {
+		return hooks.Pre
+	}
+	return hooks.Post
+}
+
+// errHooksPending is returned by the changes of a project which wait for
+// hooks to be called, they are called in the background and the change is
+// applied again
+var errHooksPending = errors.New("hooks are pending")
+
+// hookCall is a phase of the hooks of a stage, called without holding the
+// lock of the project
+type hookCall struct {
+	namespace string
+	name      string
+	appId     string
+	stage     int64
+	phase     string
+	// the rollout the hooks are called for
+	stagesStarted  int64
+	stageStartedAt string
+
+	hooks   []models.Hook
+	results []models.HookResult
+	// claimed is set while the call is in flight for this change
+	claimed bool
+	// recorded is set once the results are recorded in the app
+	recorded bool
+}
+
+func (call *hookCall) key() string {
+	return fmt.Sprintf("%s/%s/%d/%s/%d/%s", models.ProjectKey(call.namespace, call.name), call.appId, call.stage, call.phase,
+		call.stagesStarted, call.stageStartedAt)
+}
+
+// hookCalls collects the hooks the change of a project waits for, with
+// their results once called
+type hookCalls struct {
+	calls []*hookCall
+}
+
+// results returns the results of the hooks of the given phase of a stage
+// once they have been called, otherwise the call is added to the pending
+// ones and ok is false
+func (h *hookCalls) results(project *models.Project, application models.AppUpdateStage, stage int64, phase string) ([]models.HookResult, bool) {
+	hooks := stageHooks(application, stage, phase)
+	if len(hooks) == 0 {
+		return nil, true
+	}
+	for _, call := range h.calls {
+		if call.appId != application.AppId || call.stage != stage || call.phase != phase ||
+			call.stagesStarted != application.StagesStarted || call.stageStartedAt != application.StageStartedAt {
+			continue
+		}
+		if call.results == nil {
+			return nil, false
+		}
+		call.recorded = true
+		return call.results, true
+	}
+	h.calls = append(h.calls, &hookCall{
+		namespace:      project.Namespace,
+		name:           project.Name,
+		appId:          application.AppId,
+		stage:          stage,
+		phase:          phase,
+		stagesStarted:  application.StagesStarted,
+		stageStartedAt: application.StageStartedAt,
+		hooks:          hooks,
+	})
+	return nil, false
+}
+
+// call calls the claimed hooks and returns them, the project must not be
+// locked
+func (h *hookCalls) call() []*hookCall {
+	var called []*hookCall
+	for _, call := range h.calls {
+		if call.claimed && call.results == nil {
+			call.results = callHooks(models.ProjectKey(call.namespace, call.name), call.appId, call.stage, call.phase, call.hooks)
+			called = append(called, call)
+		}
+	}
+	return called
+}
+
+// recordUnused records the results of the hooks called for a rollout which
+// moved on before they were used, it returns whether project has been changed
+func (h *hookCalls) recordUnused(project *models.Project) bool {
+	changed := false
+	for _, call := range h.calls {
+		if call.results == nil || call.recorded {
+			continue
+		}
+		call.recorded = true
+		if application := findApp(project, call.appId); application != nil {
+			for _, result := range call.results {
+				recordHookResult(application, result)
+			}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// hookRuns holds the hook calls in flight, a hook is not called again for
+// another change until its results are recorded
+type hookRuns struct {
+	mu    sync.Mutex
+	calls map[string]bool
+}
+
+// claim claims the pending calls of hooks which are not in flight yet and
+// drops the others, it returns whether a claimed call is pending
+func (r *hookRuns) claim(hooks *hookCalls) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls == nil {
+		r.calls = make(map[string]bool)
+	}
+
+	claimed := false
+	calls := hooks.calls[:0]
+	for _, call := range hooks.calls {
+		if call.results == nil && !call.claimed {
+			if r.calls[call.key()] {
+				continue
+			}
+			r.calls[call.key()] = true
+			call.claimed = true
+		}
+		claimed = claimed || call.claimed && call.results == nil
+		calls = append(calls, call)
+	}
+	hooks.calls = calls
+	return claimed
+}
+
+// release releases the claimed calls of hooks which have been called
+func (r *hookRuns) release(hooks *hookCalls) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, call := range hooks.calls {
+		if call.claimed && call.results != nil {
+			delete(r.calls, call.key())
+			call.claimed = false
+		}
+	}
+}
+
+// runHooks records the results of the hooks of the given phase of a stage,
+// it returns errHooksPending until they have been called and the error of
+// the first failing hook
+func (hs *HamalService) runHooks(project *models.Project, application *models.AppUpdateStage, stage int64, phase string, hooks *hookCalls) error {
+	results, ok := hooks.results(project, *application, stage, phase)
+	if !ok {
+		return errHooksPending
+	}
+	for _, result := range results {
+		recordHookResult(application, result)
+		if !result.Success {
+			log.Warnf("project %s app %s: %s hook %s of stage %d failed: %s",
+				project.Key(), application.AppId, phase, result.Name, stage, result.Error)
+			return fmt.Errorf("%s hook %s of stage %d failed: %s", phase, result.Name, stage, result.Error)
+		}
+	}
+	return nil
+}
+
+// callHooks calls hooks in order, it stops at the first failing hook
+func callHooks(project, appId string, stage int64, phase string, hooks []models.Hook) []models.HookResult {
+	var results []models.HookResult
+	for n, hook := range hooks {
+		result := callHook(hook, hookPayload{
+			Project: project,
+			AppId:   appId,
+			Stage:   stage,
+			Phase:   phase,
+		})
+		result.Stage = stage
+		result.Phase = phase
+		if result.Name == "" {
+			result.Name = fmt.Sprintf("%s-%d", phase, n)
+		}
+		results = append(results, result)
+		if !result.Success {
+			break
+		}
+	}
+	return results
+}
+
+func recordHookResult(application *models.AppUpdateStage, result models.HookResult) {
+	application.HookResults = append(application.HookResults, result)
+	if len(application.HookResults) > MaxHookResults {
+		application.HookResults = application.HookResults[len(application.HookResults)-MaxHookResults:]
+	}
+}
+
+// runPostHooks records the post hooks of the last started stage once it is
+// done, only the first time, it returns whether application has been changed
+func (hs *HamalService) runPostHooks(project *models.Project, application *models.AppUpdateStage, hooks *hookCalls) (bool, error) {
+	if application.PostHooksRun >= application.StagesStarted {
+		return false, nil
+	}
+	if err := hs.runHooks(project, application, application.StagesStarted-1, models.HookPost, hooks); err == errHooksPending {
+		return false, err
+	} else if err != nil {
+		return true, err
+	}
+	application.PostHooksRun = application.StagesStarted
+	return true, nil
+}
+
+// preHookFailed reports whether the last pre hook called for stage since
+// the previous stage was done failed, triggers then wait for the stage to be
+// retried by hand
+func preHookFailed(application models.AppUpdateStage, stage int64) bool {
+	completed, _ := time.Parse(time.RFC3339Nano, application.StageCompletedAt)
+	for n := len(application.HookResults) - 1; n >= 0; n-- {
+		result := application.HookResults[n]
+		if result.Stage != stage || result.Phase != models.HookPre {
+			continue
+		}
+		called, err := time.Parse(time.RFC3339Nano, result.Time)
+		return err == nil && called.After(completed) && !result.Success
+	}
+	return false
+}
+
+// callHook calls hook until it succeeds or its retries are exhausted
+func callHook(hook models.Hook, payload hookPayload) models.HookResult {
+	result := models.HookResult{Name: hook.Name}
+
+	method := hook.Method
+	if method == "" {
+		method = "POST"
+	}
+	body := []byte(hook.Body)
+	if hook.Body == "" && method != "GET" {
+		body, _ = json.Marshal(payload)
+	}
+	timeout := DefaultHookTimeout
+	if d, err := time.ParseDuration(hook.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	client := &http.Client{Timeout: timeout}
+
+	for result.Attempts <= hook.Retries {
+		if result.Attempts > 0 {
+			time.Sleep(HookRetryDelay)
+		}
+		result.Attempts++
+		result.Status, result.Output, result.Error = 0, "", ""
+
+		req, err := http.NewRequest(method, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		if len(body) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for k, v := range hook.Headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		output, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MaxHookOutput))
+		resp.Body.Close()
+		result.Status = resp.StatusCode
+		result.Output = string(output)
+		if expectedStatus(hook, resp.StatusCode) {
+			result.Success = true
+			break
+		}
+		result.Error = "unexpected status " + resp.Status
+	}
+	result.Time = time.Now().Format(time.RFC3339Nano)
+	return result
+}
+
+func expectedStatus(hook models.Hook, status int) bool {
+	if len(hook.ExpectStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, s := range hook.ExpectStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
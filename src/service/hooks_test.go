@@ -0,0 +1,146 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func TestHooksAroundStages(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	hooks := newHookServer(t, http.StatusOK)
+
+	policies := stages(models.TriggerManual, models.TriggerAuto)
+	policies[0].Hooks = &models.StageHooks{Post: []models.Hook{{Name: "smoke", URL: hooks.URL}}}
+	policies[1].Hooks = &models.StageHooks{Pre: []models.Hook{{Name: "warn", URL: hooks.URL}}}
+	ts.create(t, "shop", "web", policies...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	application := ts.waitState(t, "shop", "web", models.StateSucceeded)
+	if hooks.count() != 2 {
+		t.Errorf("hooks called %d times, want 2", hooks.count())
+	}
+	if len(application.HookResults) != 2 || application.HookResults[0].Name != "smoke" ||
+		application.HookResults[1].Name != "warn" {
+		t.Errorf("hook results %+v, want smoke then warn", application.HookResults)
+	}
+}
+
+func TestFailingPreHookKeepsStage(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	hooks := newHookServer(t, http.StatusInternalServerError)
+
+	policies := stages(models.TriggerManual, models.TriggerManual)
+	policies[0].Hooks = &models.StageHooks{Pre: []models.Hook{{Name: "gate", URL: hooks.URL}}}
+	ts.create(t, "shop", "web", policies...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	application := ts.waitFor(t, "shop", "web", "a hook result", func(application models.AppUpdateStage) bool {
+		return len(application.HookResults) > 0
+	})
+	application = ts.hold(t, "shop", "web", 100*time.Millisecond)
+	if application.StagesStarted != 0 {
+		t.Errorf("%d stages started after a failing pre hook", application.StagesStarted)
+	}
+	if len(application.HookResults) != 1 || application.HookResults[0].Success {
+		t.Errorf("hook results %+v, want one failure", application.HookResults)
+	}
+	app, err := ts.swan.App("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.ProposedVersion != nil {
+		t.Error("swan was asked to update after a failing pre hook")
+	}
+}
+
+func TestHooksRunWithoutProjectLock(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+
+	// the hook changes the project it is called for, it would wait for the
+	// lock until it times out if the hooks were called under it
+	approved := make(chan error, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ts.Approve("shop", "web", 1, "alice")
+		approved <- err
+	}))
+	t.Cleanup(hook.Close)
+
+	policies := stages(models.TriggerManual, models.TriggerAuto)
+	policies[0].Hooks = &models.StageHooks{Pre: []models.Hook{{Name: "approve", URL: hook.URL, Timeout: "2s"}}}
+	policies[1].Approval = &models.ApprovalPolicy{Required: 1}
+	ts.create(t, "shop", "web", policies...)
+
+	start := time.Now()
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-approved; err != nil {
+		t.Fatalf("approval from the hook: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("rolling update took %s, the hook waited for the project lock", elapsed)
+	}
+	// the approval of the hook is kept with its result
+	application := ts.waitState(t, "shop", "web", models.StateSucceeded)
+	if len(application.Approvals) != 1 || len(application.HookResults) != 1 || !application.HookResults[0].Success {
+		t.Errorf("approvals %+v and hook results %+v, want the ones of the hook", application.Approvals, application.HookResults)
+	}
+}
+
+func TestRollingUpdateDoesNotWaitForHooks(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	release := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(hook.Close)
+	t.Cleanup(func() { close(release) })
+
+	policies := stages(models.TriggerManual, models.TriggerManual)
+	policies[0].Hooks = &models.StageHooks{Pre: []models.Hook{{Name: "slow", URL: hook.URL, Timeout: "5s"}}}
+	ts.create(t, "shop", "web", policies...)
+
+	start := time.Now()
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("rolling update took %s, it waited for the hook", elapsed)
+	}
+	// the stage starts once the hook answers
+	if application := ts.app(t, "shop", "web"); application.StagesStarted != 0 {
+		t.Errorf("stage started before its pre hook answered")
+	}
+	release <- struct{}{}
+	ts.waitStage(t, "shop", "web", 1)
+}
+
+func TestValidateHooks(t *testing.T) {
+	for _, hook := range []models.Hook{
+		{URL: "ftp://example.com/hook"},
+		{URL: "http://"},
+		{URL: "http://example.com/hook", Method: "TRACE"},
+		{URL: "http://example.com/hook", Timeout: "soon"},
+		{URL: "http://example.com/hook", Retries: -1},
+		{URL: "http://example.com/hook", ExpectStatus: []int{99}},
+	} {
+		if err := validateHook(hook); err == nil {
+			t.Errorf("invalid hook %+v accepted", hook)
+		}
+	}
+	if err := validateHook(models.Hook{URL: "https://example.com/hook", Method: "PUT", Timeout: "5s", Retries: 2}); err != nil {
+		t.Error(err)
+	}
+}
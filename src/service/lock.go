@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/store"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// projectLocks serializes the changes of each project, the reads of the
+// store need no lock since it only hands out copies
+type projectLocks struct {
+	mu    sync.Mutex
+	locks map[string]*projectLock
+}
+
+type projectLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks the project with the given key and returns the function
+// unlocking it
+func (l *projectLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*projectLock)
+	}
+	pl, ok := l.locks[key]
+	if !ok {
+		pl = &projectLock{}
+		l.locks[key] = pl
+	}
+	pl.refs++
+	l.mu.Unlock()
+
+	pl.mu.Lock()
+	return func() {
+		pl.mu.Unlock()
+		l.mu.Lock()
+		if pl.refs--; pl.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// updateProject applies change to the stored project under its lock and
+// saves the project when change reports it changed, even when change fails
+func (hs *HamalService) updateProject(key string, change func(project *models.Project) (bool, error)) (*models.Project, error) {
+	unlock := hs.locks.lock(key)
+	defer unlock()
+
+	project, err := hs.Store.Get(key)
+	if err == store.ErrNotExist {
+		return nil, errors.New("project " + key + " is not exist")
+	} else if err != nil {
+		return nil, err
+	}
+	changed, err := change(project)
+	if changed {
+		if perr := hs.Store.Put(project); perr != nil {
+			return nil, perr
+		}
+	}
+	return project, err
+}
+
+// changeFunc changes a locked project with the swan apps fetched for it, the
+// hooks it waits for are collected in hooks
+type changeFunc func(project *models.Project, apps swanApps, hooks *hookCalls) (bool, error)
+
+// changeProject applies change to project under its lock with the swan apps
+// of the given apps, all of them unless appIds is nil, fetched before the
+// project is locked. The hooks change waits for are
+// called in the background, then change is applied again with fresh swan
+// apps so it records their results and goes on, neither the caller nor the
+// lock waits for a hook.
+func (hs *HamalService) changeProject(project *models.Project, appIds map[string]bool, change changeFunc) (*models.Project, error) {
+	hooks := &hookCalls{}
+	current, err := hs.applyChange(project, appIds, change, hooks)
+	if err == errHooksPending {
+		err = nil
+	}
+	if current != nil && hs.hookRuns.claim(hooks) {
+		go hs.finishChange(project.Key(), appIds, change, hooks)
+	}
+	return current, err
+}
+
+func (hs *HamalService) applyChange(project *models.Project, appIds map[string]bool, change changeFunc, hooks *hookCalls) (*models.Project, error) {
+	apps := hs.fetchApps(project, appIds)
+	return hs.updateProject(project.Key(), func(project *models.Project) (bool, error) {
+		changed, err := change(project, apps, hooks)
+		if hooks.recordUnused(project) {
+			changed = true
+		}
+		return changed, err
+	})
+}
+
+// finishChange calls the hooks claimed for change and applies it again,
+// until it waits for no more hooks
+func (hs *HamalService) finishChange(name string, appIds map[string]bool, change changeFunc, hooks *hookCalls) {
+	for {
+		for _, call := range hooks.call() {
+			hs.auditHooks(call)
+		}
+		project, err := hs.Store.Get(name)
+		if err == nil {
+			project, err = hs.applyChange(project, appIds, change, hooks)
+		}
+		hs.hookRuns.release(hooks)
+		if err != nil && err != errHooksPending {
+			log.Errorf("project %s change after hooks error: %v", name, err)
+		}
+		if project == nil || !hs.hookRuns.claim(hooks) {
+			return
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// namespaceFile declares the namespaces and their quotas, the file is read
+// again when it changes so namespaces are added without a restart
+type namespaceFile struct {
+	path string
+
+	mu         sync.RWMutex
+	modTime    time.Time
+	namespaces map[string]models.Namespace
+}
+
+func newNamespaceFile(path string) (*namespaceFile, error) {
+	f := &namespaceFile{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// get returns the declared namespace, the default namespace always exists
+func (f *namespaceFile) get(name string) (models.Namespace, bool) {
+	if err := f.reload(); err != nil {
+		// keep the namespaces loaded last
+		log.Errorf("reload namespaces file %s error: %v", f.path, err)
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	namespace, ok := f.namespaces[name]
+	if !ok && name == models.DefaultNamespace {
+		return models.Namespace{Name: name}, true
+	}
+	return namespace, ok
+}
+
+func (f *namespaceFile) list() []models.Namespace {
+	f.get(models.DefaultNamespace)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	namespaces := make([]models.Namespace, 0, len(f.namespaces))
+	for _, namespace := range f.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+func (f *namespaceFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.RLock()
+	unchanged := info.ModTime().Equal(f.modTime)
+	f.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	var file struct {
+		Namespaces []models.Namespace `json:"namespaces"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return errors.New("invalid namespaces file: " + err.Error())
+	}
+	namespaces := make(map[string]models.Namespace)
+	for n, namespace := range file.Namespaces {
+		if err := models.ValidateNamespace(namespace.Name); err != nil {
+			return fmt.Errorf("namespace %d: %v", n, err)
+		}
+		if _, ok := namespaces[namespace.Name]; ok {
+			return fmt.Errorf("namespace %s is declared twice", namespace.Name)
+		}
+		if namespace.Quota.Projects < 0 || namespace.Quota.Apps < 0 {
+			return fmt.Errorf("namespace %s: negative quota", namespace.Name)
+		}
+		namespace.Usage = nil
+		namespaces[namespace.Name] = namespace
+	}
+
+	f.mu.Lock()
+	f.namespaces = namespaces
+	f.modTime = info.ModTime()
+	f.mu.Unlock()
+	log.Infof("loaded %d namespaces from %s", len(namespaces), f.path)
+	return nil
+}
+
+// namespace returns the namespace of the given name, any namespace exists
+// without quota when no namespaces file is configured
+func (hs *HamalService) namespace(name string) (models.Namespace, error) {
+	if name == "" {
+		name = models.DefaultNamespace
+	}
+	if err := models.ValidateNamespace(name); err != nil {
+		return models.Namespace{}, err
+	}
+	if hs.namespaces == nil {
+		return models.Namespace{Name: name}, nil
+	}
+	namespace, ok := hs.namespaces.get(name)
+	if !ok {
+		return models.Namespace{}, errors.New("namespace " + name + " is not exist")
+	}
+	return namespace, nil
+}
+
+// checkQuota checks that the namespace of project exists and has room for
+// it, in place of the project it replaces when updated
+func (hs *HamalService) checkQuota(project *models.Project) error {
+	namespace, err := hs.namespace(project.Namespace)
+	if err != nil {
+		return err
+	}
+	if namespace.Quota.Projects == 0 && namespace.Quota.Apps == 0 {
+		return nil
+	}
+
+	projects, err := hs.Store.List()
+	if err != nil {
+		return err
+	}
+	usage := models.Usage{Projects: 1, Apps: len(project.Applications)}
+	for _, p := range projects {
+		if p.InNamespace(namespace.Name) && p.Key() != project.Key() {
+			usage.Projects++
+			usage.Apps += len(p.Applications)
+		}
+	}
+	if namespace.Quota.Projects > 0 && usage.Projects > namespace.Quota.Projects {
+		return fmt.Errorf("namespace %s is limited to %d projects", namespace.Name, namespace.Quota.Projects)
+	}
+	if namespace.Quota.Apps > 0 && usage.Apps > namespace.Quota.Apps {
+		return fmt.Errorf("namespace %s is limited to %d apps, %d requested",
+			namespace.Name, namespace.Quota.Apps, usage.Apps)
+	}
+	return nil
+}
+
+// GetNamespaces returns the declared namespaces and the ones holding
+// projects, with their usage
+func (hs *HamalService) GetNamespaces() ([]models.Namespace, error) {
+	hs.PMutex.Lock()
+	defer hs.PMutex.Unlock()
+	projects, err := hs.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := map[string]models.Namespace{
+		models.DefaultNamespace: {Name: models.DefaultNamespace},
+	}
+	if hs.namespaces != nil {
+		for _, namespace := range hs.namespaces.list() {
+			namespaces[namespace.Name] = namespace
+		}
+	}
+	usage := make(map[string]*models.Usage)
+	for name := range namespaces {
+		usage[name] = &models.Usage{}
+	}
+	for _, project := range projects {
+		name := project.Namespace
+		if name == "" {
+			name = models.DefaultNamespace
+		}
+		if _, ok := usage[name]; !ok {
+			// left over from a namespace removed from the file
+			namespaces[name] = models.Namespace{Name: name}
+			usage[name] = &models.Usage{}
+		}
+		usage[name].Projects++
+		usage[name].Apps += len(project.Applications)
+	}
+
+	result := make([]models.Namespace, 0, len(namespaces))
+	for name, namespace := range namespaces {
+		namespace.Usage = usage[name]
+		result = append(result, namespace)
+	}
+	sort.Sort(byNamespaceName(result))
+	return result, nil
+}
+
+type byNamespaceName []models.Namespace
+
+func (s byNamespaceName) Len() int           { return len(s) }
+func (s byNamespaceName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byNamespaceName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
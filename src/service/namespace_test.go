@@ -0,0 +1,52 @@
+package service
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func TestNamespaceQuota(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "namespaces.json")
+	data := `{"namespaces": [{"name": "payments", "quota": {"projects": 1}}]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestService(t)
+	namespaces, err := newNamespaceFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.namespaces = namespaces
+	ts.addApp(t, "web", 1)
+
+	inNamespace := func(namespace, name string) *models.Project {
+		project := newProject(name, "web", stages(models.TriggerManual)...)
+		project.Namespace = namespace
+		return project
+	}
+	ts.createProject(t, inNamespace("payments", "shop"))
+	if err := ts.CreateOrUpdateProject(inNamespace("payments", "blog")); err == nil {
+		t.Error("project beyond the quota of payments created")
+	}
+	if err := ts.CreateOrUpdateProject(inNamespace("billing", "shop")); err == nil {
+		t.Error("project created in an undeclared namespace")
+	}
+	// the name is free in the other namespaces
+	ts.createProject(t, inNamespace("", "shop"))
+
+	if _, err := ts.Store.Get(models.ProjectKey("payments", "shop")); err != nil {
+		t.Errorf("namespaced shop not stored: %v", err)
+	}
+	listed, err := ts.GetNamespaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, namespace := range listed {
+		if namespace.Usage == nil || namespace.Usage.Projects != 1 {
+			t.Errorf("namespace %s used by %+v, want 1 project", namespace.Name, namespace.Usage)
+		}
+	}
+}
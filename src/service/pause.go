@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/store"
+)
+
+// SetPaused pauses or resumes the project, or only one of its apps when
+// appId is set. A paused app finishes the stage swan is running but no
+// further stage is started until it is resumed.
+func (hs *HamalService) SetPaused(projectName, appId string, paused bool) (*models.Project, error) {
+	project, err := hs.Store.Get(projectName)
+	if err == store.ErrNotExist {
+		return nil, errors.New("project " + projectName + " not exist")
+	} else if err != nil {
+		return nil, err
+	}
+	var appIds map[string]bool
+	if appId != "" {
+		appIds = map[string]bool{appId: true}
+	}
+	project, err = hs.changeProject(project, appIds, func(project *models.Project, fetched swanApps, hooks *hookCalls) (bool, error) {
+		apps := project.Applications
+		if appId == "" {
+			project.Paused = paused
+		} else {
+			application := findApp(project, appId)
+			if application == nil {
+				return false, errors.New("app " + appId + " not exist in project " + projectName)
+			}
+			application.Paused = paused
+			apps = []models.AppUpdateStage{*application}
+		}
+
+		for _, a := range apps {
+			application := findApp(project, a.AppId)
+			reason := pauseReason(project, *application)
+			switch {
+			case reason != "" && !inFlight(*application):
+				if application.State == models.StatePending {
+					setState(application, models.StatePaused, reason)
+				}
+			case reason != "":
+				hs.reconcileApp(project, application, fetched.get(*application), hooks)
+			case application.State == models.StatePaused && !inFlight(*application):
+				setState(application, models.StatePending, "")
+			default:
+				// observe swan for the real state of the resumed rollout
+				hs.reconcileApp(project, application, fetched.get(*application), hooks)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return maskProject(project), nil
+}
+
+// pauseReason tells whether the app is paused, by itself or by its project
+func pauseReason(project *models.Project, application models.AppUpdateStage) string {
+	if project.Paused {
+		return "paused with project " + project.Key()
+	}
+	if application.Paused {
+		return "paused"
+	}
+	return ""
+}
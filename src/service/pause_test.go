@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func TestPauseHoldsNextStage(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.swan.StartDelay = 50 * time.Millisecond
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerAuto)...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	// the stage in flight goes on while the project is paused
+	if _, err := ts.SetPaused("shop", "", true); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitFor(t, "shop", "web", "stage 0 updated", func(application models.AppUpdateStage) bool {
+		app := ts.swanApp(t, "web")
+		return app.ProposedVersion != nil && versionHealthy(app, app.ProposedVersion)
+	})
+	application := ts.hold(t, "shop", "web", 100*time.Millisecond)
+	if application.State != models.StatePaused || application.StagesStarted != 1 {
+		t.Errorf("paused app is %s at stage %d", application.State, application.StagesStarted)
+	}
+	if err := ts.RollingUpdate("shop", "web"); err == nil {
+		t.Error("rolling update of a paused project passed")
+	}
+
+	if _, err := ts.SetPaused("shop", "", false); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitState(t, "shop", "web", models.StateSucceeded)
+}
+
+func TestPauseApp(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual)...)
+
+	project, err := ts.SetPaused("shop", "web", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Paused || project.Applications[0].State != models.StatePaused {
+		t.Errorf("pause of app web left project paused %v and app %s", project.Paused, project.Applications[0].State)
+	}
+	if err := ts.RollingUpdate("shop", "web"); err == nil {
+		t.Error("rolling update of a paused app passed")
+	}
+	if ts.swanApp(t, "web").ProposedVersion != nil {
+		t.Error("swan was asked to update a paused app")
+	}
+
+	if project, err = ts.SetPaused("shop", "web", false); err != nil {
+		t.Fatal(err)
+	}
+	if project.Applications[0].State != models.StatePending {
+		t.Errorf("resumed app is %s, want %s", project.Applications[0].State, models.StatePending)
+	}
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/store"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+const (
+	StageDone       = "done"
+	StageInProgress = "in progress"
+	StagePending    = "pending"
+)
+
+// Plan returns what a rolling update of the stored project would do, it
+// changes nothing, the stored state is at most one reconcile interval old
+func (hs *HamalService) Plan(name string) (*models.Plan, error) {
+	project, err := hs.Store.Get(name)
+	if err == store.ErrNotExist {
+		return nil, errors.New("project " + name + " is not exist")
+	} else if err != nil {
+		return nil, err
+	}
+	return hs.plan(project)
+}
+
+// DryRunProject validates project as a create would and returns its plan,
+// the project is not saved
+func (hs *HamalService) DryRunProject(project *models.Project) (*models.Plan, error) {
+	if err := hs.validateNewProject(project); err != nil {
+		return nil, err
+	}
+	for n := range project.Applications {
+		newRollout(&project.Applications[n])
+	}
+	return hs.plan(project)
+}
+
+// plan only reads from swan
+func (hs *HamalService) plan(project *models.Project) (*models.Plan, error) {
+	plan := &models.Plan{Name: project.Name}
+	for _, application := range project.Applications {
+		app, err := hs.GetApp(application.Cluster, application.AppId)
+		if err != nil {
+			return nil, err
+		}
+
+		ap := models.AppPlan{
+			AppId:         application.AppId,
+			State:         application.State,
+			TargetVersion: maskVersion(application.App),
+			NextStage:     -1,
+		}
+
+		var current types.Version
+		if app.CurrentVersion != nil {
+			current = versionSpec(*app.CurrentVersion)
+			masked := maskVersion(*app.CurrentVersion)
+			ap.CurrentVersion = &masked
+		}
+		if ap.Changes, err = diffFields(current, versionSpec(application.App)); err != nil {
+			return nil, err
+		}
+		if ap.Changes, err = maskDiffs(ap.Changes, current, application.App); err != nil {
+			return nil, err
+		}
+
+		for n, rp := range application.RollingUpdatePolicy {
+			trigger := rp.Trigger
+			if trigger == "" {
+				trigger = models.TriggerManual
+			}
+			sp := models.StagePlan{
+				Stage:     int64(n),
+				Instances: stageInstances(application, n, int64(app.Instances)),
+				Trigger:   trigger,
+				State:     StagePending,
+			}
+			if int64(n) < application.NextStage {
+				sp.State = StageDone
+			} else if int64(n) < application.StagesStarted {
+				sp.State = StageInProgress
+			}
+			ap.Stages = append(ap.Stages, sp)
+		}
+
+		ap.NextStage, ap.Blocked = hs.nextStage(project, application)
+		plan.Applications = append(plan.Applications, ap)
+	}
+	return plan, nil
+}
+
+// nextStage returns the stage a rolling update of application would start,
+// or -1 and the reason it would be refused
+func (hs *HamalService) nextStage(project *models.Project, application models.AppUpdateStage) (int64, string) {
+	stage := application.StagesStarted
+	switch application.State {
+	case models.StateUpdating:
+		return -1, "stage " + strconv.FormatInt(stage-1, 10) + " is in progress"
+	case models.StateVerifying:
+		return -1, "stage " + strconv.FormatInt(stage-1, 10) + " is verifying"
+	case models.StateFailed:
+		if stage > 0 {
+			return -1, "app is failed: " + application.Reason
+		}
+	case models.StateSucceeded:
+		return -1, "app is updated"
+	}
+	if reason := pauseReason(project, application); reason != "" {
+		return -1, "app is " + reason
+	}
+	if int(stage) >= len(application.RollingUpdatePolicy) {
+		return -1, "no stage left"
+	}
+	if stage == 0 {
+		if pending := pendingDependencies(project, application); len(pending) > 0 {
+			return -1, "waits for " + strings.Join(pending, ", ") + " to be updated"
+		}
+		if pending := hs.pendingClusters(project, application); len(pending) > 0 {
+			return -1, "waits for cluster " + strings.Join(pending, ", ") + " to be updated"
+		}
+	}
+	if pending := approvalPending(application, stage); pending != "" {
+		return -1, pending
+	}
+	return stage, ""
+}
+
+// versionSpec clears the fields swan sets on the versions it stores
+func versionSpec(v types.Version) types.Version {
+	v.ID = ""
+	v.AppID = ""
+	v.PreviousVersionID = ""
+	return v
+}
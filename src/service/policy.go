@@ -0,0 +1,105 @@
+package service
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// validatePolicy checks the stages of application without looking at swan
+func validatePolicy(application models.AppUpdateStage) error {
+	var percentage float64
+	for n, rp := range application.RollingUpdatePolicy {
+		switch {
+		case rp.InstancesToUpdate < 0 || rp.Percentage < 0 || rp.MaxUnavailable < 0:
+			return fmt.Errorf("app %s stage %d: negative instances", application.AppId, n)
+		case rp.InstancesToUpdate > 0 && rp.Percentage > 0:
+			return fmt.Errorf("app %s stage %d: both instances_to_update and percentage set", application.AppId, n)
+		case rp.InstancesToUpdate == 0 && rp.Percentage == 0:
+			return fmt.Errorf("app %s stage %d: no instances to update", application.AppId, n)
+		}
+		percentage += rp.Percentage
+	}
+	if percentage > 100+1e-9 {
+		return fmt.Errorf("app %s: stages update %g%% of the instances", application.AppId, percentage)
+	}
+	return nil
+}
+
+// validateCoverage checks that the stages of application update every
+// instance of the live swan app, each of them at least one
+func validateCoverage(application models.AppUpdateStage, app types.App) error {
+	var total int64
+	for n, count := range resolveStages(application.RollingUpdatePolicy, int64(app.Instances)) {
+		if count <= 0 {
+			return fmt.Errorf("app %s stage %d: no instances to update out of %d",
+				application.AppId, n, app.Instances)
+		}
+		total += count
+	}
+	if total != int64(app.Instances) {
+		return fmt.Errorf("app %s: stages update %d of %d instances", application.AppId, total, app.Instances)
+	}
+	return nil
+}
+
+// resolveStages returns the instance count of every stage for an app of the
+// given size.
+//
+// Absolute stages count as is. Percentage stages are resolved on the
+// cumulative percentage rounded up, so rounding never drifts and a policy
+// adding up to 100% always covers the whole app; every percentage stage
+// updates at least one instance while any is left.
+func resolveStages(policies []models.AppUpdatePolicy, instances int64) []int64 {
+	counts := make([]int64, len(policies))
+	var absolute, done int64
+	var percentage float64
+	for n, rp := range policies {
+		var target int64
+		if rp.Percentage > 0 {
+			percentage += rp.Percentage
+			target = absolute + int64(math.Ceil(percentage*float64(instances)/100-1e-9))
+			if target <= done {
+				target = done + 1
+			}
+			if target > instances {
+				target = instances
+			}
+		} else {
+			absolute += rp.InstancesToUpdate
+			target = done + rp.InstancesToUpdate
+		}
+		if target < done {
+			target = done
+		}
+		counts[n] = target - done
+		done = target
+	}
+	return counts
+}
+
+// stageInstances returns the instance count of stage, the started stages
+// keep the count they were resolved to when they started
+func stageInstances(application models.AppUpdateStage, stage int, instances int64) int64 {
+	if stage < len(application.StageInstances) {
+		return application.StageInstances[stage]
+	}
+	return resolveStages(application.RollingUpdatePolicy, instances)[stage]
+}
+
+// unavailableTasks counts the tasks of app which are not running
+func unavailableTasks(app types.App) int64 {
+	var count int64
+	for _, task := range app.Tasks {
+		state := task.Status
+		if task.CurrentTask != nil {
+			state = task.CurrentTask.State
+		}
+		if state != TaskRunning {
+			count++
+		}
+	}
+	return count
+}
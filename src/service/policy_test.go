@@ -0,0 +1,88 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+func absolute(counts ...int64) []models.AppUpdatePolicy {
+	var policies []models.AppUpdatePolicy
+	for _, count := range counts {
+		policies = append(policies, models.AppUpdatePolicy{InstancesToUpdate: count})
+	}
+	return policies
+}
+
+func percentages(percents ...float64) []models.AppUpdatePolicy {
+	var policies []models.AppUpdatePolicy
+	for _, percent := range percents {
+		policies = append(policies, models.AppUpdatePolicy{Percentage: percent})
+	}
+	return policies
+}
+
+func TestResolveStages(t *testing.T) {
+	for _, tc := range []struct {
+		policies  []models.AppUpdatePolicy
+		instances int64
+		want      []int64
+	}{
+		{absolute(1, 2, 3), 6, []int64{1, 2, 3}},
+		{percentages(10, 40, 50), 10, []int64{1, 4, 5}},
+		// the cumulative percentage is rounded up, it never drifts
+		{percentages(33.3, 33.3, 33.4), 10, []int64{4, 3, 3}},
+		// every percentage stage updates one instance while any is left
+		{percentages(1, 1, 98), 3, []int64{1, 1, 1}},
+		{percentages(50, 50, 50), 2, []int64{1, 1, 0}},
+		{append(absolute(1), percentages(50, 50)...), 5, []int64{1, 3, 1}},
+	} {
+		if got := resolveStages(tc.policies, tc.instances); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("stages %+v of %d instances resolved to %v, want %v", tc.policies, tc.instances, got, tc.want)
+		}
+	}
+}
+
+func TestValidateCoverage(t *testing.T) {
+	for _, tc := range []struct {
+		policies  []models.AppUpdatePolicy
+		instances int
+		valid     bool
+	}{
+		{absolute(1, 2), 3, true},
+		{percentages(25, 75), 3, true},
+		{percentages(33.3, 33.3, 33.4), 3, true},
+		{append(absolute(1), percentages(100)...), 4, true},
+		// short of the live instances
+		{absolute(1, 1), 3, false},
+		{percentages(50), 4, false},
+		// past the live instances
+		{absolute(2, 2), 3, false},
+		// a stage updating nothing
+		{absolute(1, 0, 2), 3, false},
+		{percentages(50, 50, 50), 2, false},
+	} {
+		application := models.AppUpdateStage{AppId: "web", RollingUpdatePolicy: tc.policies}
+		err := validateCoverage(application, types.App{Instances: tc.instances})
+		if (err == nil) != tc.valid {
+			t.Errorf("stages %+v of %d instances: %v, want valid %v", tc.policies, tc.instances, err, tc.valid)
+		}
+	}
+}
+
+func TestUpdateProjectChecksCoverage(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 3)
+	project := ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual, models.TriggerManual)...)
+
+	project.Applications[0].RollingUpdatePolicy = stages(models.TriggerManual, models.TriggerManual)
+	if err := ts.UpdateProject(project); err == nil {
+		t.Error("project updated with stages short of the live instances")
+	}
+	project.Applications[0].RollingUpdatePolicy = percentages(50, 50)
+	if err := ts.UpdateProject(project); err != nil {
+		t.Errorf("project with stages covering the live instances not updated: %v", err)
+	}
+}
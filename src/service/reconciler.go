@@ -0,0 +1,382 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/store"
+	"github.com/Dataman-Cloud/swan/src/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultReconcileInterval is how often the rollouts are reconciled with
+	// swan when no interval is configured
+	DefaultReconcileInterval = 5 * time.Second
+	// MaxTransitions is how many state transitions are kept per app
+	MaxTransitions = 100
+)
+
+// runReconciler reconciles every rollout each interval, or each
+// pollInterval while the swan event stream is connected, and the apps named
+// by the swan events as soon as they arrive
+func (hs *HamalService) runReconciler(interval, pollInterval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReconcileInterval
+	}
+	if pollInterval <= 0 {
+		pollInterval = DefaultSwanPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			if hs.streamConnected() && time.Since(last) < pollInterval {
+				continue
+			}
+		case appId := <-hs.kicks:
+			apps := map[string]bool{appId: true}
+			for wait := time.After(KickDelay); wait != nil; {
+				select {
+				case appId := <-hs.kicks:
+					apps[appId] = true
+				case <-wait:
+					wait = nil
+				}
+			}
+			if !apps[""] {
+				hs.reconcileApps(apps)
+				continue
+			}
+		}
+		hs.reconcileAll()
+		last = time.Now()
+	}
+}
+
+// ReconcileProject observes swan for the project right away and returns the
+// reconciled project
+func (hs *HamalService) ReconcileProject(name string) (*models.Project, error) {
+	project, err := hs.Store.Get(name)
+	if err == store.ErrNotExist {
+		return nil, errors.New("project " + name + " is not exist")
+	} else if err != nil {
+		return nil, err
+	}
+	if project, err = hs.reconcileStored(project, nil); err != nil {
+		return nil, err
+	}
+	project.Clusters = hs.clusterProgress(project)
+	return maskProject(project), nil
+}
+
+func (hs *HamalService) reconcileAll() {
+	projects, err := hs.Store.List()
+	if err != nil {
+		log.Errorf("reconciler list projects error: %v", err)
+		return
+	}
+
+	for _, project := range projects {
+		if _, err := hs.reconcileStored(project, nil); err != nil {
+			log.Errorf("reconciler save project %s error: %v", project.Key(), err)
+		}
+	}
+}
+
+// reconcileApps reconciles the given apps in every project holding them
+func (hs *HamalService) reconcileApps(apps map[string]bool) {
+	projects, err := hs.Store.List()
+	if err != nil {
+		log.Errorf("reconciler list projects error: %v", err)
+		return
+	}
+
+	for _, project := range projects {
+		held := false
+		for _, application := range project.Applications {
+			held = held || apps[application.AppId]
+		}
+		if !held {
+			continue
+		}
+		if _, err := hs.reconcileStored(project, apps); err != nil {
+			log.Errorf("reconciler save project %s error: %v", project.Key(), err)
+		}
+	}
+}
+
+// reconcileStored fetches the swan apps of project without holding its
+// lock, then reconciles the stored project with them, only the given apps
+// are reconciled unless appIds is nil
+func (hs *HamalService) reconcileStored(project *models.Project, appIds map[string]bool) (*models.Project, error) {
+	return hs.changeProject(project, appIds, func(project *models.Project, apps swanApps, hooks *hookCalls) (bool, error) {
+		return hs.reconcileProject(project, apps, appIds, hooks), nil
+	})
+}
+
+// swanApps holds the swan apps fetched for the rollouts in flight of a
+// project, by app id
+type swanApps map[string]fetchedApp
+
+type fetchedApp struct {
+	app types.App
+	// the rollout the app was fetched for
+	stagesStarted int64
+	transitionAt  string
+}
+
+// fetchApps gets the swan apps of the rollouts in flight of project, or of
+// the given apps of it unless appIds is nil, swan is called before the
+// project is locked so a slow swan holds no lock
+func (hs *HamalService) fetchApps(project *models.Project, appIds map[string]bool) swanApps {
+	apps := make(swanApps)
+	for _, application := range project.Applications {
+		if appIds != nil && !appIds[application.AppId] || !inFlight(application) {
+			continue
+		}
+		app, err := hs.GetApp(application.Cluster, application.AppId)
+		if err != nil {
+			log.Errorf("reconciler get app %s error: %v", application.AppId, err)
+			continue
+		}
+		apps[application.AppId] = fetchedApp{
+			app:           app,
+			stagesStarted: application.StagesStarted,
+			transitionAt:  lastTransitionAt(application),
+		}
+	}
+	return apps
+}
+
+// get returns the swan app fetched for application, nil when none was or
+// the rollout of application moved on since it was fetched
+func (apps swanApps) get(application models.AppUpdateStage) *types.App {
+	fetched, ok := apps[application.AppId]
+	if !ok || fetched.stagesStarted != application.StagesStarted ||
+		fetched.transitionAt != lastTransitionAt(application) {
+		return nil
+	}
+	return &fetched.app
+}
+
+// lastTransitionAt returns the time of the last state change of application
+func lastTransitionAt(application models.AppUpdateStage) string {
+	if len(application.Transitions) == 0 {
+		return ""
+	}
+	return application.Transitions[len(application.Transitions)-1].Time
+}
+
+// reconcileProject advances the state of the apps of project with the swan
+// apps fetched for them, of the given apps unless appIds is nil, it returns
+// whether project has been changed
+func (hs *HamalService) reconcileProject(project *models.Project, apps swanApps, appIds map[string]bool, hooks *hookCalls) bool {
+	changed := false
+	for n := range project.Applications {
+		application := &project.Applications[n]
+		if appIds != nil && !appIds[application.AppId] {
+			continue
+		}
+		if hs.reconcileApp(project, application, apps.get(*application), hooks) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// reconcileApp observes the swan app of application while its rollout is in
+// flight, rolls it back when it fails, tracks the health of the updated
+// tasks and starts the next stage once its trigger is satisfied, nothing is
+// observed when app is nil. The hooks are called in the background, the app
+// waits for them in its state.
+func (hs *HamalService) reconcileApp(project *models.Project, application *models.AppUpdateStage, fetched *types.App, hooks *hookCalls) bool {
+	changed := false
+	if application.State == "" {
+		changed = setState(application, models.StatePending, "")
+	}
+	if !inFlight(*application) || fetched == nil {
+		return changed
+	}
+	app := *fetched
+	hs.observeTasks(project.Key(), application.AppId, app)
+
+	if hs.autoRollback(project, application, app) {
+		return true
+	}
+	if trackProgress(application, app) {
+		changed = true
+	}
+	if hs.checkDeadlines(project, application) {
+		return true
+	}
+	if hs.verifyHealth(application, app) {
+		changed = true
+	}
+
+	state, reason := hs.observe(application, app)
+	if state == models.StateRolledBack {
+		rollBack(application, reason)
+		return true
+	}
+	if state == models.StatePending || state == models.StateSucceeded {
+		ran, err := hs.runPostHooks(project, application, hooks)
+		if err == errHooksPending {
+			return changed
+		} else if err != nil {
+			state, reason = models.StateFailed, err.Error()
+		}
+		if ran {
+			changed = true
+		}
+	}
+	if state == models.StatePending && application.StageCompletedAt == "" {
+		application.StageCompletedAt = time.Now().Format(time.RFC3339Nano)
+	}
+	if pause := pauseReason(project, *application); pause != "" {
+		switch state {
+		case models.StatePending, models.StateUpdating, models.StateVerifying:
+			// swan finishes the stage in flight, the next one is held
+			state, reason = models.StatePaused, pause
+		}
+	}
+	if setState(application, state, reason) {
+		changed = true
+	}
+
+	if application.State == models.StatePending && hs.runTrigger(project, application, hooks) {
+		changed = true
+	}
+	return changed
+}
+
+// observe returns the state of the started rollout of application as seen
+// in the swan app
+func (hs *HamalService) observe(application *models.AppUpdateStage, app types.App) (string, string) {
+	if app.ProposedVersion == nil {
+		if int(application.StagesStarted) >= len(application.RollingUpdatePolicy) {
+			return models.StateSucceeded, ""
+		}
+		return models.StateRolledBack, "update cancelled in swan"
+	}
+
+	var target int64
+	for n := 0; n < int(application.StagesStarted); n++ {
+		target += stageInstances(*application, n, int64(app.Instances))
+	}
+	if versionTasks(app, app.ProposedVersion) != target {
+		return models.StateUpdating, ""
+	}
+	if !hs.stageHealthy(*application, app) {
+		return models.StateVerifying, ""
+	}
+	if int(application.StagesStarted) >= len(application.RollingUpdatePolicy) {
+		return models.StateVerifying, "waiting for swan to finish the update"
+	}
+	return models.StatePending, ""
+}
+
+// inFlight reports whether application has started stages to watch
+func inFlight(application models.AppUpdateStage) bool {
+	if application.StagesStarted == 0 {
+		return false
+	}
+	switch application.State {
+	case models.StatePending, models.StateUpdating, models.StateVerifying, models.StatePaused:
+		return true
+	}
+	return false
+}
+
+// setState moves application to state, records the transition and updates
+// the status fields derived from it, it returns whether the state changed
+func setState(application *models.AppUpdateStage, state, reason string) bool {
+	if application.State == state && application.Reason == reason {
+		updateStatus(application)
+		return false
+	}
+
+	application.Transitions = append(application.Transitions, models.Transition{
+		From:   application.State,
+		To:     state,
+		Stage:  application.StagesStarted,
+		Reason: reason,
+		Time:   time.Now().Format(time.RFC3339Nano),
+	})
+	if len(application.Transitions) > MaxTransitions {
+		application.Transitions = application.Transitions[len(application.Transitions)-MaxTransitions:]
+	}
+	if application.State != state {
+		log.Infof("app %s: %s -> %s %s", application.AppId, application.State, state, reason)
+	}
+	application.State = state
+	application.Reason = reason
+	updateStatus(application)
+	return true
+}
+
+// updateStatus derives the status and next stage reported to the clients
+func updateStatus(application *models.AppUpdateStage) {
+	application.NextStage = application.StagesStarted
+	switch application.State {
+	case models.StatePending:
+		application.Status = DeployCreated
+		if application.StagesStarted > 0 {
+			application.Status = DeployIng
+		}
+	case models.StateUpdating:
+		application.Status = DeployIng
+		application.NextStage = application.StagesStarted - 1
+	case models.StatePaused:
+		application.Status = DeployPaused
+	case models.StateVerifying:
+		application.Status = DeployVerifying
+		application.NextStage = application.StagesStarted - 1
+	case models.StateSucceeded:
+		application.Status = DeploySuccess
+	case models.StateFailed:
+		application.Status = DeployFailed
+	case models.StateRolledBack:
+		application.Status = DeployCreated
+	default:
+		application.Status = Undefined
+	}
+	if application.NextStage < 0 {
+		application.NextStage = 0
+	}
+}
+
+// rollBack moves application to StateRolledBack and forgets its started
+// stages, the transition keeps the stages started until then
+func rollBack(application *models.AppUpdateStage, reason string) {
+	setState(application, models.StateRolledBack, reason)
+	resetRollout(application)
+	updateStatus(application)
+}
+
+// resetRollout forgets the started stages of application
+func resetRollout(application *models.AppUpdateStage) {
+	application.StagesStarted = 0
+	application.StageInstances = nil
+	application.Approvals = nil
+	application.StageStartedAt = ""
+	application.StageCompletedAt = ""
+	application.UpdatedTasks = 0
+	application.ProgressAt = ""
+	application.HealthySince = ""
+	application.PostHooksRun = 0
+}
+
+// newRollout resets application to a rollout which has not started yet
+func newRollout(application *models.AppUpdateStage) {
+	resetRollout(application)
+	application.State = ""
+	application.Reason = ""
+	application.Transitions = nil
+	setState(application, models.StatePending, "")
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/store"
+)
+
+// Revert makes a version the app ran before the target of its rollout and
+// starts the first stage, the following stages go as for any update.
+// Dependencies are not waited for, a revert is usually urgent.
+func (hs *HamalService) Revert(projectName, appId, versionId string) error {
+	project, err := hs.Store.Get(projectName)
+	if err == store.ErrNotExist {
+		return errors.New("project " + projectName + " not exist")
+	} else if err != nil {
+		return err
+	}
+	_, err = hs.changeProject(project, nil, func(project *models.Project, apps swanApps, hooks *hookCalls) (bool, error) {
+		application := findApp(project, appId)
+		if application == nil {
+			return false, errors.New("app " + appId + " not exist in project " + projectName)
+		}
+		changed := hs.reconcileProject(project, apps, nil, hooks)
+		if inFlight(*application) {
+			return changed, errors.New("app " + appId + " is being updated, roll it back first")
+		}
+		if len(application.RollingUpdatePolicy) == 0 {
+			return changed, errors.New("app " + appId + " has no stage")
+		}
+
+		app, err := hs.GetApp(application.Cluster, appId)
+		if err != nil {
+			return changed, err
+		}
+		if app.CurrentVersion != nil && app.CurrentVersion.ID == versionId {
+			return changed, errors.New("app " + appId + " is running version " + versionId)
+		}
+		if !hasVersion(app, versionId) {
+			return changed, errors.New("version " + versionId + " not exist in app " + appId)
+		}
+
+		version, err := hs.GetAppVersion(application.Cluster, appId, versionId)
+		if err != nil {
+			return changed, err
+		}
+		application.App = versionSpec(version)
+		resetRollout(application)
+		setState(application, models.StatePending, "revert to version "+versionId)
+
+		project.Status = 1
+		if pending := approvalPending(*application, 0); pending != "" {
+			return true, errors.New("revert to version " + versionId + " is pending, " + pending)
+		}
+		return true, hs.startStage(project, application, 0, "revert to version "+versionId, hooks)
+	})
+	return err
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func TestRevert(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	previous := ts.swanApp(t, "web").CurrentVersion.ID
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerAuto)...)
+
+	if err := ts.Revert("shop", "web", previous); err == nil {
+		t.Error("revert to the running version passed")
+	}
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitState(t, "shop", "web", models.StateSucceeded)
+	if err := ts.Revert("shop", "web", "unknown"); err == nil {
+		t.Error("revert to an unknown version passed")
+	}
+
+	if err := ts.Revert("shop", "web", previous); err != nil {
+		t.Fatal(err)
+	}
+	application := ts.waitState(t, "shop", "web", models.StateSucceeded)
+	if application.App.Command != "sleep 100" {
+		t.Errorf("project rolls out %q after the revert, want the previous version", application.App.Command)
+	}
+	if app := ts.swanApp(t, "web"); app.ProposedVersion != nil || app.CurrentVersion.Command != "sleep 100" {
+		t.Errorf("swan runs %q after the revert, want the previous version", app.CurrentVersion.Command)
+	}
+}
+
+func TestRevertDuringRollout(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	previous := ts.swanApp(t, "web").CurrentVersion.ID
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual)...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Revert("shop", "web", previous); err == nil {
+		t.Error("revert of an app being updated passed")
+	}
+}
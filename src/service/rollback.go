@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	TaskRunning = "TASK_RUNNING"
+	TaskFailed  = "TASK_FAILED"
+	TaskLost    = "TASK_LOST"
+	TaskError   = "TASK_ERROR"
+)
+
+// autoRollback rolls application back when the rollback policy of the
+// running stage is met, it returns whether application has been changed
+func (hs *HamalService) autoRollback(project *models.Project, application *models.AppUpdateStage, app types.App) bool {
+	if app.ProposedVersion == nil || application.StagesStarted == 0 ||
+		int(application.StagesStarted) > len(application.RollingUpdatePolicy) {
+		return false
+	}
+
+	policy := application.RollingUpdatePolicy[application.StagesStarted-1].RollbackPolicy
+	if !policy.AutoRollback {
+		return false
+	}
+
+	condition := policy.RollbackCondition
+	if condition <= 0 {
+		condition = 1
+	}
+	failures := versionFailures(app, app.ProposedVersion)
+	if failures < condition {
+		return false
+	}
+
+	reason := fmt.Sprintf("auto rollback at stage %d: %d task failures of version %s",
+		application.StagesStarted-1, failures, app.ProposedVersion.ID)
+	log.Warnf("project %s app %s: %s", project.Key(), application.AppId, reason)
+	if err := hs.rollback(project, application.AppId, reason); err != nil {
+		log.Errorf("project %s app %s auto rollback error: %v", project.Key(), application.AppId, err)
+		setState(application, models.StateFailed, reason+", rollback error: "+err.Error())
+	}
+	return true
+}
+
+// versionFailures counts the failed runs and the unhealthy running tasks of
+// the given version, tasks still in their health check grace period are not
+// counted as unhealthy
+func versionFailures(app types.App, version *types.Version) int64 {
+	var grace time.Duration
+	for _, hc := range version.HealthChecks {
+		if d := time.Duration(hc.GracePeriodSeconds * float64(time.Second)); d > grace {
+			grace = d
+		}
+	}
+
+	var failures int64
+	for _, task := range app.Tasks {
+		if task.VersionID != version.ID {
+			continue
+		}
+
+		for _, history := range task.History {
+			if taskStateFailed(history.State) {
+				failures++
+			}
+		}
+		if task.CurrentTask == nil {
+			continue
+		}
+		if taskStateFailed(task.CurrentTask.State) {
+			failures++
+		} else if task.CurrentTask.State == TaskRunning && len(version.HealthChecks) > 0 &&
+			!task.Healthy && time.Since(task.Created) > grace {
+			failures++
+		}
+	}
+	return failures
+}
+
+func taskStateFailed(state string) bool {
+	return state == TaskFailed || state == TaskLost || state == TaskError
+}
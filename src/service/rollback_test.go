@@ -0,0 +1,72 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/fakeswan"
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func TestAutoRollbackOnFailedTasks(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 3)
+	policies := stages(models.TriggerManual, models.TriggerManual, models.TriggerAuto)
+	policies[1].RollbackPolicy = models.AppRollbackPolicy{AutoRollback: true, RollbackCondition: 1}
+	ts.create(t, "shop", "web", policies...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitStage(t, "shop", "web", 1)
+	// the task moved by stage 1 fails
+	if err := ts.swan.SetFaults("web", fakeswan.Faults{FailTasks: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	application := ts.waitState(t, "shop", "web", models.StateRolledBack)
+	if !strings.HasPrefix(application.Reason, "auto rollback at stage 1: 1 task failures") {
+		t.Errorf("rolled back for %q", application.Reason)
+	}
+	if application.StagesStarted != 0 {
+		t.Errorf("%d stages started after the rollback, want 0", application.StagesStarted)
+	}
+	if app := ts.swanApp(t, "web"); app.ProposedVersion != nil || app.CurrentVersion.Command != "sleep 100" {
+		t.Errorf("swan runs %q after the rollback, want the previous version", app.CurrentVersion.Command)
+	}
+}
+
+func TestFailedTasksWithoutAutoRollback(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual)...)
+	if err := ts.swan.SetFaults("web", fakeswan.Faults{FailTasks: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitFor(t, "shop", "web", "a failed task", func(application models.AppUpdateStage) bool {
+		app := ts.swanApp(t, "web")
+		return app.ProposedVersion != nil && versionFailures(app, app.ProposedVersion) > 0
+	})
+	application := ts.hold(t, "shop", "web", 100*time.Millisecond)
+	if application.State == models.StateRolledBack || application.State == models.StatePending {
+		t.Errorf("app is %s with a failed task and no auto rollback", application.State)
+	}
+	if ts.swanApp(t, "web").ProposedVersion == nil {
+		t.Error("update cancelled without auto rollback")
+	}
+
+	if err := ts.Rollback("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if application := ts.app(t, "shop", "web"); application.State != models.StateRolledBack || application.Reason != "manual rollback" {
+		t.Errorf("app is %s (%s) after a manual rollback", application.State, application.Reason)
+	}
+}
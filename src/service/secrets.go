@@ -0,0 +1,189 @@
+package service
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// MaskedValue replaces the values of secret looking env variables, docker
+// parameters and arguments in the versions and diffs returned to clients
+const MaskedValue = "******"
+
+var secretEnv = regexp.MustCompile(`(?i)(pass|secret|token|key|credential|private|auth)`)
+
+// maskVersion returns a copy of v hiding the values of the env variables,
+// docker parameters and arguments whose name looks like they hold a secret
+func maskVersion(v types.Version) types.Version {
+	if len(v.Env) > 0 {
+		env := make(map[string]string, len(v.Env))
+		for name, value := range v.Env {
+			if secretEnv.MatchString(name) {
+				value = MaskedValue
+			}
+			env[name] = value
+		}
+		v.Env = env
+	}
+	v.Args = maskArgs(v.Args)
+	if fields := strings.Fields(v.Command); len(fields) > 0 {
+		masked := maskArgs(fields)
+		for n := range fields {
+			if masked[n] != fields[n] {
+				v.Command = strings.Join(masked, " ")
+				break
+			}
+		}
+	}
+
+	if v.Container != nil && v.Container.Docker != nil && len(v.Container.Docker.Parameters) > 0 {
+		container := *v.Container
+		docker := *container.Docker
+		docker.Parameters = make([]*types.Parameter, len(v.Container.Docker.Parameters))
+		for n, p := range v.Container.Docker.Parameters {
+			if p == nil {
+				continue
+			}
+			masked := *p
+			if secretEnv.MatchString(p.Key) {
+				masked.Value = MaskedValue
+			} else {
+				// env, label and the like hold name=value pairs
+				masked.Value = maskAssignment(p.Value)
+			}
+			docker.Parameters[n] = &masked
+		}
+		container.Docker = &docker
+		v.Container = &container
+	}
+	return v
+}
+
+// maskArgs returns a copy of args hiding the values of the secret looking
+// flags, as --password=value or --password value, and of name=value pairs
+func maskArgs(args []string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	masked := make([]string, len(args))
+	copy(masked, args)
+	for n, arg := range args {
+		if strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=") {
+			if secretEnv.MatchString(arg) && n+1 < len(args) && !strings.HasPrefix(args[n+1], "-") {
+				masked[n+1] = MaskedValue
+			}
+			continue
+		}
+		if masked[n] != MaskedValue {
+			masked[n] = maskAssignment(arg)
+		}
+	}
+	return masked
+}
+
+// maskAssignment hides the value of a name=value pair whose name looks like
+// it holds a secret
+func maskAssignment(s string) string {
+	n := strings.Index(s, "=")
+	if n <= 0 || !secretEnv.MatchString(s[:n]) {
+		return s
+	}
+	return s[:n+1] + MaskedValue
+}
+
+// maskDiffs hides the secret values of the diffs between the versions old
+// and new, a changed secret is still reported
+func maskDiffs(diffs []models.FieldDiff, old, new types.Version) ([]models.FieldDiff, error) {
+	oldFields, err := flatten(maskVersion(old))
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flatten(maskVersion(new))
+	if err != nil {
+		return nil, err
+	}
+	for n, d := range diffs {
+		if d.Old != nil {
+			diffs[n].Old = oldFields[d.Field]
+		}
+		if d.New != nil {
+			diffs[n].New = newFields[d.Field]
+		}
+	}
+	return diffs, nil
+}
+
+// maskProject hides the secrets of the versions the apps of project roll
+// out, of the hooks of their stages and of its notification urls, the
+// project is the copy returned to a client
+func maskProject(project *models.Project) *models.Project {
+	for n := range project.Notifications {
+		project.Notifications[n].URL = redactURL(project.Notifications[n].URL)
+	}
+	for n := range project.Applications {
+		application := &project.Applications[n]
+		application.App = maskVersion(application.App)
+		for i, policy := range application.RollingUpdatePolicy {
+			if policy.Hooks == nil {
+				continue
+			}
+			application.RollingUpdatePolicy[i].Hooks = &models.StageHooks{
+				Pre:  maskHooks(policy.Hooks.Pre),
+				Post: maskHooks(policy.Hooks.Post),
+			}
+		}
+	}
+	return project
+}
+
+// redactURL keeps the scheme and the host of a url, webhook urls usually
+// hold their credentials in the path or the query
+func redactURL(s string) string {
+	if s == "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return MaskedValue
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// maskHooks returns a copy of hooks hiding their header values and bodies,
+// they usually carry the credentials of the endpoints
+func maskHooks(hooks []models.Hook) []models.Hook {
+	if len(hooks) == 0 {
+		return hooks
+	}
+	masked := make([]models.Hook, len(hooks))
+	for n, hook := range hooks {
+		if len(hook.Headers) > 0 {
+			headers := make(map[string]string, len(hook.Headers))
+			for name := range hook.Headers {
+				headers[name] = MaskedValue
+			}
+			hook.Headers = headers
+		}
+		if hook.Body != "" {
+			hook.Body = MaskedValue
+		}
+		masked[n] = hook
+	}
+	return masked
+}
+
+// MaskApp returns a copy of app whose versions hide their secrets
+func MaskApp(app types.App) types.App {
+	if app.CurrentVersion != nil {
+		current := maskVersion(*app.CurrentVersion)
+		app.CurrentVersion = &current
+	}
+	if app.ProposedVersion != nil {
+		proposed := maskVersion(*app.ProposedVersion)
+		app.ProposedVersion = &proposed
+	}
+	return app
+}
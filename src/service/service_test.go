@@ -0,0 +1,203 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/audit"
+	"github.com/Dataman-Cloud/hamal/src/fakeswan"
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/store"
+	"github.com/Dataman-Cloud/hamal/src/swanclient"
+	"github.com/Dataman-Cloud/swan/src/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testCluster names the cluster of the fake swan server
+const testCluster = "test"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testService is a service rolling out the apps of a fake swan cluster
+type testService struct {
+	*HamalService
+	swan *fakeswan.Server
+}
+
+func newTestService(t *testing.T) *testService {
+	fs := fakeswan.New(testCluster)
+	server := httptest.NewServer(fs)
+	t.Cleanup(server.Close)
+
+	client, err := swanclient.New(server.URL, swanclient.WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testService{
+		HamalService: &HamalService{
+			Clusters:          map[string]*swanclient.Client{testCluster: client},
+			Store:             store.NewMemoryStore(),
+			PMutex:            new(sync.Mutex),
+			HealthGracePeriod: 10 * time.Millisecond,
+			Audit:             audit.NewMemoryLog(),
+			kicks:             make(chan string, 64),
+		},
+		swan: fs,
+	}
+}
+
+// addApp adds an app of the given instances to the fake swan cluster
+func (ts *testService) addApp(t *testing.T, appId string, instances int32) {
+	if _, err := ts.swan.AddApp(appId, types.Version{
+		AppID:     appId,
+		RunAs:     "test",
+		Instances: instances,
+		Command:   "sleep 100",
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newProject returns a project rolling out a new command to the app in the
+// given stages
+func newProject(name, appId string, stages ...models.AppUpdatePolicy) *models.Project {
+	return &models.Project{
+		Name: name,
+		Applications: []models.AppUpdateStage{{
+			AppId:               appId,
+			App:                 types.Version{AppID: appId, RunAs: "test", Command: "sleep 200"},
+			RollingUpdatePolicy: stages,
+		}},
+	}
+}
+
+// create creates the project of newProject
+func (ts *testService) create(t *testing.T, name, appId string, stages ...models.AppUpdatePolicy) *models.Project {
+	return ts.createProject(t, newProject(name, appId, stages...))
+}
+
+func (ts *testService) createProject(t *testing.T, project *models.Project) *models.Project {
+	t.Helper()
+	if err := ts.CreateOrUpdateProject(project); err != nil {
+		t.Fatal(err)
+	}
+	return project
+}
+
+func (ts *testService) app(t *testing.T, name, appId string) models.AppUpdateStage {
+	project, err := ts.Store.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	application := findApp(project, appId)
+	if application == nil {
+		t.Fatalf("app %s not in project %s", appId, name)
+	}
+	return *application
+}
+
+// waitFor reconciles the project until done holds for the app
+func (ts *testService) waitFor(t *testing.T, name, appId, want string, done func(models.AppUpdateStage) bool) models.AppUpdateStage {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := ts.ReconcileProject(name); err != nil {
+			t.Fatal(err)
+		}
+		application := ts.app(t, name, appId)
+		if done(application) {
+			return application
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("app %s is %s (%s) at stage %d after 5s, want %s",
+				appId, application.State, application.Reason, application.StagesStarted, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitState reconciles the project until the app reaches state
+func (ts *testService) waitState(t *testing.T, name, appId, state string) models.AppUpdateStage {
+	t.Helper()
+	return ts.waitFor(t, name, appId, state, func(application models.AppUpdateStage) bool {
+		return application.State == state
+	})
+}
+
+// waitStage reconciles the project until the stages started by the app are
+// done, it waits for the next one
+func (ts *testService) waitStage(t *testing.T, name, appId string, started int64) models.AppUpdateStage {
+	t.Helper()
+	return ts.waitFor(t, name, appId, fmt.Sprintf("stage %d done", started-1), func(application models.AppUpdateStage) bool {
+		return application.StagesStarted == started && application.StageCompletedAt != ""
+	})
+}
+
+// hold reconciles the project for a while and fails if the app starts
+// another stage meanwhile
+func (ts *testService) hold(t *testing.T, name, appId string, d time.Duration) models.AppUpdateStage {
+	t.Helper()
+	started := ts.app(t, name, appId).StagesStarted
+	for end := time.Now().Add(d); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		if _, err := ts.ReconcileProject(name); err != nil {
+			t.Fatal(err)
+		}
+		if application := ts.app(t, name, appId); application.StagesStarted != started {
+			t.Fatalf("app %s started stage %d while held (%s)", appId, started, application.State)
+		}
+	}
+	return ts.app(t, name, appId)
+}
+
+// swanApp returns the app in the fake swan cluster
+func (ts *testService) swanApp(t *testing.T, appId string) types.App {
+	t.Helper()
+	app, err := ts.swan.App(appId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func stages(triggers ...string) []models.AppUpdatePolicy {
+	var policies []models.AppUpdatePolicy
+	for _, trigger := range triggers {
+		policies = append(policies, models.AppUpdatePolicy{InstancesToUpdate: 1, Trigger: trigger})
+	}
+	return policies
+}
+
+// hookServer records the hooks it receives and answers them with status
+type hookServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	calls  int
+	status int
+}
+
+func newHookServer(t *testing.T, status int) *hookServer {
+	h := &hookServer{status: status}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.calls++
+		h.mu.Unlock()
+		w.WriteHeader(h.status)
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (h *hookServer) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.calls
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/store"
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+// Events pushed to the clients following a project
+const (
+	// StreamSnapshot carries the whole project when the stream starts
+	StreamSnapshot = "snapshot"
+	// StreamTransition carries a state transition of an app
+	StreamTransition = "transition"
+	// StreamStage carries the stage progress of an app when it changes
+	StreamStage = "stage"
+	// StreamTask carries a task whose version, state or health changed
+	StreamTask = "task"
+	// StreamDeleted ends the stream of a deleted project
+	StreamDeleted = "deleted"
+)
+
+// StreamEvent is a change of a followed project
+type StreamEvent struct {
+	Type       string             `json:"type"`
+	AppId      string             `json:"app_id,omitempty"`
+	Project    *models.Project    `json:"project,omitempty"`
+	Transition *models.Transition `json:"transition,omitempty"`
+	Stage      *StageProgress     `json:"stage,omitempty"`
+	Task       *TaskProgress      `json:"task,omitempty"`
+}
+
+// StageProgress is the rollout progress of an app
+type StageProgress struct {
+	State         string `json:"state"`
+	Status        string `json:"status"`
+	StagesStarted int64  `json:"stages_started"`
+	NextStage     int64  `json:"next_stage"`
+}
+
+// TaskProgress is a swan task as observed by the reconciler
+type TaskProgress struct {
+	ID        string `json:"id"`
+	VersionID string `json:"version_id"`
+	State     string `json:"state,omitempty"`
+	Healthy   bool   `json:"healthy"`
+	Removed   bool   `json:"removed,omitempty"`
+}
+
+// appTasks are the tasks of an app observed during a reconciliation
+type appTasks struct {
+	project string
+	appId   string
+	tasks   []TaskProgress
+}
+
+// taskFeed fans the observed tasks out to the followers of the projects
+type taskFeed struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan *appTasks
+}
+
+func (f *taskFeed) add() (<-chan *appTasks, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[int]chan *appTasks)
+	}
+
+	id := f.next
+	f.next++
+	ch := make(chan *appTasks, 64)
+	f.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			delete(f.subs, id)
+			close(ch)
+		})
+	}
+}
+
+// notify never blocks, slow followers miss observations rather than stall
+// the reconciler
+func (f *taskFeed) notify(t *appTasks) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ch := range f.subs {
+		select {
+		case ch <- t:
+		default:
+		}
+	}
+}
+
+// observeTasks publishes the tasks of app to the followers of project
+func (hs *HamalService) observeTasks(project string, appId string, app types.App) {
+	tasks := make([]TaskProgress, 0, len(app.Tasks))
+	for _, task := range app.Tasks {
+		state := task.Status
+		if task.CurrentTask != nil {
+			state = task.CurrentTask.State
+		}
+		tasks = append(tasks, TaskProgress{
+			ID:        task.ID,
+			VersionID: task.VersionID,
+			State:     state,
+			Healthy:   task.Healthy,
+		})
+	}
+	hs.tasks.notify(&appTasks{project: project, appId: appId, tasks: tasks})
+}
+
+// Follow streams the changes of the named project, starting with a snapshot
+// of it. The returned function stops the stream and closes the channel.
+func (hs *HamalService) Follow(name string) (<-chan *StreamEvent, func(), error) {
+	changes, stopChanges := hs.Store.Watch()
+	observed, stopTasks := hs.tasks.add()
+	project, err := hs.Store.Get(name)
+	if err != nil {
+		stopChanges()
+		stopTasks()
+		if err == store.ErrNotExist {
+			return nil, nil, errors.New("project " + name + " not exist")
+		}
+		return nil, nil, err
+	}
+
+	out := make(chan *StreamEvent, 64)
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() { once.Do(func() { close(done) }) }
+
+	go func() {
+		defer close(out)
+		defer stopChanges()
+		defer stopTasks()
+
+		send := func(e *StreamEvent) bool {
+			select {
+			case out <- e:
+				return true
+			case <-done:
+				return false
+			}
+		}
+		if !send(&StreamEvent{Type: StreamSnapshot, Project: maskProject(project)}) {
+			return
+		}
+
+		known := make(map[string]map[string]TaskProgress)
+		for {
+			select {
+			case <-done:
+				return
+			case e, ok := <-changes:
+				if !ok {
+					return
+				}
+				if e.Name != name {
+					continue
+				}
+				if e.Type == store.EventDelete {
+					send(&StreamEvent{Type: StreamDeleted})
+					return
+				}
+				for _, event := range projectChanges(project, e.Project) {
+					if !send(event) {
+						return
+					}
+				}
+				project = e.Project
+			case t, ok := <-observed:
+				if !ok {
+					return
+				}
+				if t.project != name {
+					continue
+				}
+				for _, event := range taskChanges(known, t) {
+					if !send(event) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return out, stop, nil
+}
+
+// projectChanges returns the transitions and stage changes between two
+// versions of a project
+func projectChanges(before, after *models.Project) []*StreamEvent {
+	var events []*StreamEvent
+	for n := range after.Applications {
+		application := after.Applications[n]
+		var since time.Time
+		previous := findApp(before, application.AppId)
+		if previous != nil {
+			since = lastTransition(*previous)
+		}
+
+		for i := range application.Transitions {
+			t := application.Transitions[i]
+			if at, err := time.Parse(time.RFC3339Nano, t.Time); err != nil || !at.After(since) {
+				continue
+			}
+			events = append(events, &StreamEvent{Type: StreamTransition, AppId: application.AppId, Transition: &t})
+		}
+
+		progress := StageProgress{
+			State:         application.State,
+			Status:        application.Status,
+			StagesStarted: application.StagesStarted,
+			NextStage:     application.NextStage,
+		}
+		if previous == nil || progress != (StageProgress{
+			State:         previous.State,
+			Status:        previous.Status,
+			StagesStarted: previous.StagesStarted,
+			NextStage:     previous.NextStage,
+		}) {
+			events = append(events, &StreamEvent{Type: StreamStage, AppId: application.AppId, Stage: &progress})
+		}
+	}
+	return events
+}
+
+// taskChanges returns the tasks which changed since the last observation of
+// their app, and the ones which are gone
+func taskChanges(known map[string]map[string]TaskProgress, t *appTasks) []*StreamEvent {
+	var events []*StreamEvent
+	previous := known[t.appId]
+	current := make(map[string]TaskProgress, len(t.tasks))
+	for n := range t.tasks {
+		task := t.tasks[n]
+		current[task.ID] = task
+		if old, ok := previous[task.ID]; !ok || old != task {
+			events = append(events, &StreamEvent{Type: StreamTask, AppId: t.appId, Task: &task})
+		}
+	}
+	for id, task := range previous {
+		if _, ok := current[id]; !ok {
+			task.Removed = true
+			events = append(events, &StreamEvent{Type: StreamTask, AppId: t.appId, Task: &task})
+		}
+	}
+	known[t.appId] = current
+	return events
+}
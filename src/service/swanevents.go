@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/src/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultSwanPollInterval is how often the rollouts are polled while
+	// the swan event stream is connected, for the checks based on time
+	DefaultSwanPollInterval = 30 * time.Second
+	// MinEventsBackoff and MaxEventsBackoff bound the wait between two
+	// connections to the swan event stream
+	MinEventsBackoff = time.Second
+	MaxEventsBackoff = time.Minute
+	// KickDelay gathers the swan events arriving together into one
+	// reconciliation
+	KickDelay = 500 * time.Millisecond
+)
+
+// runSwanEvents follows the event stream of a swan cluster, reconnecting
+// with backoff, and has the apps named by the events reconciled right away
+func (hs *HamalService) runSwanEvents(cluster string) {
+	backoff := MinEventsBackoff
+	for {
+		connected, err := hs.followSwanEvents(cluster)
+		if connected {
+			atomic.AddInt32(&hs.streaming, -1)
+			backoff = MinEventsBackoff
+		}
+		log.Warnf("swan cluster %s event stream error, poll until reconnected in %s: %v", cluster, backoff, err)
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > MaxEventsBackoff {
+			backoff = MaxEventsBackoff
+		}
+	}
+}
+
+// followSwanEvents reads the event stream of a swan cluster until it fails,
+// it returns whether the stream has been connected
+func (hs *HamalService) followSwanEvents(cluster string) (bool, error) {
+	swan, err := hs.swan(cluster)
+	if err != nil {
+		return false, err
+	}
+	body, err := swan.Events(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	log.Infof("swan cluster %s event stream connected", cluster)
+	atomic.AddInt32(&hs.streaming, 1)
+	// catch up with what happened while the stream was down
+	hs.kick("")
+
+	rd := bufio.NewReader(body)
+	var event string
+	var data []string
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return true, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				hs.dispatchSwanEvent(event, strings.Join(data, "\n"))
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+// dispatchSwanEvent decodes a swan event, a types.AppInfoEvent for the app_*
+// events and a types.TaskInfoEvent for the others, and kicks its app
+func (hs *HamalService) dispatchSwanEvent(event, data string) {
+	if strings.HasPrefix(event, "app_") {
+		var e types.AppInfoEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil || e.AppId == "" {
+			return
+		}
+		log.Debugf("swan event %s app %s %s", event, e.AppId, e.State)
+		hs.kick(e.AppId)
+		return
+	}
+
+	var e types.TaskInfoEvent
+	if err := json.Unmarshal([]byte(data), &e); err != nil || e.AppId == "" {
+		return
+	}
+	log.Debugf("swan event %s app %s task %s %s", event, e.AppId, e.TaskId, e.State)
+	hs.kick(e.AppId)
+}
+
+// kick asks the reconciler to observe the app now, every app when appId is
+// empty, it never blocks
+func (hs *HamalService) kick(appId string) {
+	select {
+	case hs.kicks <- appId:
+	default:
+		// the reconciler is busy, it will see the app on its next pass
+	}
+}
+
+// streamConnected reports whether the event streams of every cluster are
+// connected
+func (hs *HamalService) streamConnected() bool {
+	return int(atomic.LoadInt32(&hs.streaming)) == len(hs.Clusters)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+	"github.com/Dataman-Cloud/hamal/src/swanclient"
+)
+
+func TestFollowSwanEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != swanclient.Events {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "event: app_add\ndata: {\"AppId\":\"web\",\"State\":\"normal\"}\n\n")
+		fmt.Fprint(w, "event: task_add\ndata: {\"AppId\":\"api\",\"TaskId\":\"0-api\",\"State\":\"TASK_RUNNING\"}\n\n")
+		fmt.Fprint(w, "event: task_rm\ndata: not json\n\n")
+	}))
+	defer server.Close()
+
+	client, err := swanclient.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &HamalService{Clusters: map[string]*swanclient.Client{testCluster: client}, kicks: make(chan string, 8)}
+	connected, _ := hs.followSwanEvents(testCluster)
+	if !connected {
+		t.Fatal("swan event stream not connected")
+	}
+	close(hs.kicks)
+
+	var kicked []string
+	for appId := range hs.kicks {
+		kicked = append(kicked, appId)
+	}
+	if fmt.Sprint(kicked) != fmt.Sprint([]string{"", "web", "api"}) {
+		t.Errorf("swan events kicked %q, want a full pass then web and api", kicked)
+	}
+}
+
+func TestReconcileApps(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual)...)
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	stored := func() models.AppUpdateStage {
+		project, err := ts.Store.Get("shop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return project.Applications[0]
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stored().State != models.StatePending && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		ts.reconcileApps(map[string]bool{"api": true})
+		if stored().State == models.StatePending {
+			t.Fatal("app reconciled for the events of another app")
+		}
+		ts.reconcileApps(map[string]bool{"web": true})
+	}
+	if application := stored(); application.State != models.StatePending || application.StagesStarted != 1 {
+		t.Errorf("app is %s at stage %d after its events, want %s at stage 1",
+			application.State, application.StagesStarted, models.StatePending)
+	}
+}
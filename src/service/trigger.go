@@ -0,0 +1,42 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// runTrigger starts the pending stage of application once its trigger is
+// satisfied, it returns whether application has been changed
+func (hs *HamalService) runTrigger(project *models.Project, application *models.AppUpdateStage, hooks *hookCalls) bool {
+	stage := application.StagesStarted
+	if application.State != models.StatePending || stage == 0 || int(stage) >= len(application.RollingUpdatePolicy) {
+		return false
+	}
+
+	trigger, delay, err := application.RollingUpdatePolicy[stage].ParseTrigger()
+	if err != nil || trigger == models.TriggerManual {
+		return false
+	}
+	if approvalPending(*application, stage) != "" || preHookFailed(*application, stage) {
+		return false
+	}
+	if trigger == models.TriggerDelay {
+		completed, err := time.Parse(time.RFC3339Nano, application.StageCompletedAt)
+		if err != nil || time.Since(completed) < delay {
+			return false
+		}
+	}
+
+	if err := hs.startStage(project, application, stage, trigger+" trigger", hooks); err == errHooksPending {
+		return false
+	} else if err != nil {
+		log.Errorf("project %s app %s start stage %d error: %v", project.Key(), application.AppId, stage, err)
+		// keep the result of the failed pre hook
+		return preHookFailed(*application, stage)
+	}
+	log.Infof("project %s app %s: %s trigger started stage %d", project.Key(), application.AppId, trigger, stage)
+	return true
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func TestAutoTrigger(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 3)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerAuto, models.TriggerAuto)...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	application := ts.waitState(t, "shop", "web", models.StateSucceeded)
+	if application.StagesStarted != 3 {
+		t.Errorf("%d stages started, want 3", application.StagesStarted)
+	}
+	if app := ts.swanApp(t, "web"); app.ProposedVersion != nil || app.CurrentVersion.Command != "sleep 200" {
+		t.Errorf("swan runs %q, want the rolled out version", app.CurrentVersion.Command)
+	}
+}
+
+func TestManualStageWaits(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, models.TriggerManual)...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitStage(t, "shop", "web", 1)
+	if application := ts.hold(t, "shop", "web", 100*time.Millisecond); application.State != models.StatePending {
+		t.Errorf("app is %s after its manual stage is done, want %s", application.State, models.StatePending)
+	}
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitState(t, "shop", "web", models.StateSucceeded)
+}
+
+func TestDelayTrigger(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	ts.create(t, "shop", "web", stages(models.TriggerManual, "delay:"+delay.String())...)
+
+	if err := ts.RollingUpdate("shop", "web"); err != nil {
+		t.Fatal(err)
+	}
+	application := ts.waitStage(t, "shop", "web", 1)
+	completed, err := time.Parse(time.RFC3339Nano, application.StageCompletedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.hold(t, "shop", "web", delay/2)
+
+	ts.waitFor(t, "shop", "web", "stage 1 started", func(application models.AppUpdateStage) bool {
+		return application.StagesStarted == 2
+	})
+	if started := time.Since(completed); started < delay {
+		t.Errorf("stage 1 started %s after stage 0 was done, want %s", started, delay)
+	}
+	ts.waitState(t, "shop", "web", models.StateSucceeded)
+}
+
+func TestInvalidTrigger(t *testing.T) {
+	ts := newTestService(t)
+	ts.addApp(t, "web", 2)
+	if err := ts.CreateOrUpdateProject(newProject("shop", "web", stages(models.TriggerManual, "later")...)); err == nil {
+		t.Error("project with an invalid trigger created")
+	}
+}
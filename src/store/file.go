@@ -0,0 +1,214 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+// DefaultFilePath is used when no store path is configured
+const DefaultFilePath = "hamal.db"
+
+// FileStore persists projects in an append-only log of json records.
+//
+// The log is replayed and compacted when the store is opened, every change
+// is appended and synced to disk before it becomes visible.
+type FileStore struct {
+	mu       sync.RWMutex
+	path     string
+	file     *os.File
+	projects map[string]*models.Project
+	watchers watchers
+}
+
+type record struct {
+	Op      string          `json:"op"`
+	Name    string          `json:"name"`
+	Project *models.Project `json:"project,omitempty"`
+	// Status is the project status, which its json leaves out
+	Status int `json:"status,omitempty"`
+}
+
+// NewFileStore opens or creates the store log at path
+func NewFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		path = DefaultFilePath
+	}
+
+	fs := &FileStore{
+		path:     path,
+		projects: make(map[string]*models.Project),
+	}
+	if err := fs.replay(); err != nil {
+		return nil, err
+	}
+	if err := fs.compact(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fs.file = f
+	return fs, nil
+}
+
+// Get returns a copy of the named project
+func (fs *FileStore) Get(name string) (*models.Project, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	project, ok := fs.projects[name]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return clone(project)
+}
+
+// List returns a copy of all projects ordered by name
+func (fs *FileStore) List() ([]*models.Project, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return list(fs.projects)
+}
+
+// Put creates or replaces a project
+func (fs *FileStore) Put(project *models.Project) error {
+	p, err := clone(project)
+	if err != nil {
+		return err
+	}
+	notified, err := clone(project)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	if err := fs.append(&record{Op: EventPut, Name: p.Key(), Project: p, Status: p.Status}); err != nil {
+		fs.mu.Unlock()
+		return err
+	}
+	fs.projects[p.Key()] = p
+	fs.mu.Unlock()
+
+	fs.watchers.notify(&Event{Type: EventPut, Name: p.Key(), Project: notified})
+	return nil
+}
+
+// Delete removes a project
+func (fs *FileStore) Delete(name string) error {
+	fs.mu.Lock()
+	if _, ok := fs.projects[name]; !ok {
+		fs.mu.Unlock()
+		return ErrNotExist
+	}
+	if err := fs.append(&record{Op: EventDelete, Name: name}); err != nil {
+		fs.mu.Unlock()
+		return err
+	}
+	delete(fs.projects, name)
+	fs.mu.Unlock()
+
+	fs.watchers.notify(&Event{Type: EventDelete, Name: name})
+	return nil
+}
+
+// Watch subscribes to project changes
+func (fs *FileStore) Watch() (<-chan *Event, func()) {
+	return fs.watchers.add()
+}
+
+// Close closes the underlying log file
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.file.Close()
+}
+
+func (fs *FileStore) append(r *record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return fs.file.Sync()
+}
+
+func (fs *FileStore) replay() error {
+	f, err := os.Open(fs.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			// a partial last line is left by a crash in the middle of a write
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("store %s line %d: %v", fs.path, n, err)
+		}
+		switch r.Op {
+		case EventPut:
+			if r.Project == nil {
+				return fmt.Errorf("store %s line %d: put without project", fs.path, n)
+			}
+			r.Project.Status = r.Status
+			fs.projects[r.Name] = r.Project
+		case EventDelete:
+			delete(fs.projects, r.Name)
+		default:
+			return fmt.Errorf("store %s line %d: unknown op %s", fs.path, n, r.Op)
+		}
+	}
+}
+
+// compact rewrites the log so it only holds the current projects
+func (fs *FileStore) compact() error {
+	tmp, err := os.OpenFile(filepath.Join(filepath.Dir(fs.path), "."+filepath.Base(fs.path)+".tmp"),
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for name, project := range fs.projects {
+		data, err := json.Marshal(&record{Op: EventPut, Name: name, Project: project, Status: project.Status})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path)
+}
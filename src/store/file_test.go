@@ -0,0 +1,111 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+func openStore(t *testing.T, path string) *FileStore {
+	t.Helper()
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hamal.db")
+	fs := openStore(t, path)
+	for _, project := range []*models.Project{
+		{Name: "shop", Status: 0},
+		{Name: "blog"},
+		{Name: "shop", Status: 1},
+		{Name: "shop", Namespace: "payments"},
+		{Name: "wiki"},
+	} {
+		if err := fs.Put(project); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Delete("blog"); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	fs = openStore(t, path)
+	projects, err := fs.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 3 {
+		t.Fatalf("%d projects replayed, want 3", len(projects))
+	}
+	if shop, err := fs.Get("shop"); err != nil || shop.Status != 1 {
+		t.Errorf("shop replayed as %+v (%v), want its last put", shop, err)
+	}
+	if _, err := fs.Get("wiki"); err != nil {
+		t.Errorf("wiki not replayed: %v", err)
+	}
+	if _, err := fs.Get(models.ProjectKey("payments", "shop")); err != nil {
+		t.Errorf("namespaced shop not replayed: %v", err)
+	}
+	if _, err := fs.Get("blog"); err != ErrNotExist {
+		t.Errorf("deleted blog replayed: %v", err)
+	}
+
+	// the log is compacted to the current projects
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+		t.Errorf("%d records after compaction, want 3", lines)
+	}
+}
+
+func TestFileStoreReplayPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hamal.db")
+	fs := openStore(t, path)
+	if err := fs.Put(&models.Project{Name: "shop"}); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	// a crash in the middle of a write leaves a partial last line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","name":"blog","proj`)
+	f.Close()
+
+	fs = openStore(t, path)
+	if _, err := fs.Get("shop"); err != nil {
+		t.Errorf("shop lost after a partial write: %v", err)
+	}
+	if _, err := fs.Get("blog"); err != ErrNotExist {
+		t.Errorf("partial record replayed: %v", err)
+	}
+}
+
+func TestFileStoreReplayCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hamal.db")
+	for _, data := range []string{
+		"not json\n",
+		`{"op":"rename","name":"shop"}` + "\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFileStore(path); err == nil {
+			t.Errorf("store %q opened", data)
+		}
+	}
+}
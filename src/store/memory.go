@@ -0,0 +1,96 @@
+package store
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+// MemoryStore keeps projects in memory only, it is meant for tests
+type MemoryStore struct {
+	mu       sync.RWMutex
+	projects map[string]*models.Project
+	watchers watchers
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		projects: make(map[string]*models.Project),
+	}
+}
+
+// Get returns a copy of the named project
+func (ms *MemoryStore) Get(name string) (*models.Project, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	project, ok := ms.projects[name]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return clone(project)
+}
+
+// List returns a copy of all projects ordered by name
+func (ms *MemoryStore) List() ([]*models.Project, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return list(ms.projects)
+}
+
+// Put creates or replaces a project
+func (ms *MemoryStore) Put(project *models.Project) error {
+	p, err := clone(project)
+	if err != nil {
+		return err
+	}
+	notified, err := clone(project)
+	if err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	ms.projects[p.Key()] = p
+	ms.mu.Unlock()
+
+	ms.watchers.notify(&Event{Type: EventPut, Name: p.Key(), Project: notified})
+	return nil
+}
+
+// Delete removes a project
+func (ms *MemoryStore) Delete(name string) error {
+	ms.mu.Lock()
+	if _, ok := ms.projects[name]; !ok {
+		ms.mu.Unlock()
+		return ErrNotExist
+	}
+	delete(ms.projects, name)
+	ms.mu.Unlock()
+
+	ms.watchers.notify(&Event{Type: EventDelete, Name: name})
+	return nil
+}
+
+// Watch subscribes to project changes
+func (ms *MemoryStore) Watch() (<-chan *Event, func()) {
+	return ms.watchers.add()
+}
+
+func list(projects map[string]*models.Project) ([]*models.Project, error) {
+	names := make([]string, 0, len(projects))
+	for name := range projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*models.Project, 0, len(names))
+	for _, name := range names {
+		p, err := clone(projects[name])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/Dataman-Cloud/hamal/src/models"
+)
+
+const (
+	// EventPut is sent to watchers when a project is created or updated
+	EventPut = "put"
+	// EventDelete is sent to watchers when a project is deleted
+	EventDelete = "delete"
+)
+
+// ErrNotExist is returned when the requested project is not stored
+var ErrNotExist = errors.New("project is not exist")
+
+// Event describes a change of a stored project, Name is the key of the
+// project, see models.ProjectKey
+type Event struct {
+	Type    string          `json:"type"`
+	Name    string          `json:"name"`
+	Project *models.Project `json:"project,omitempty"`
+}
+
+// ProjectStore persists the projects managed by hamal, keyed by
+// models.ProjectKey so the same name may be used in several namespaces.
+//
+// Implementations must be safe for concurrent use. Projects returned by Get
+// and List are copies, changes only take effect once they are passed to Put.
+type ProjectStore interface {
+	Get(name string) (*models.Project, error)
+	List() ([]*models.Project, error)
+	Put(project *models.Project) error
+	Delete(name string) error
+	// Watch returns a channel receiving every change made after the call,
+	// and a function which stops the watch and closes the channel.
+	Watch() (<-chan *Event, func())
+}
+
+// watchers fans store events out to every active watch
+type watchers struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan *Event
+}
+
+func (w *watchers) add() (<-chan *Event, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subs == nil {
+		w.subs = make(map[int]chan *Event)
+	}
+
+	id := w.next
+	w.next++
+	ch := make(chan *Event, 64)
+	w.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			delete(w.subs, id)
+			close(ch)
+		})
+	}
+}
+
+// notify never blocks, slow watchers miss events rather than stall writers
+func (w *watchers) notify(e *Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func clone(project *models.Project) (*models.Project, error) {
+	data, err := json.Marshal(project)
+	if err != nil {
+		return nil, err
+	}
+	var p models.Project
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	// the status is internal and left out of the json
+	p.Status = project.Status
+	return &p, nil
+}
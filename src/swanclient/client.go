@@ -0,0 +1,310 @@
+package swanclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/src/types"
+)
+
+const (
+	// Apps is the path of the swan app api
+	Apps = "/v_beta/apps"
+	// Events is the Server-Sent Events endpoint of swan
+	Events = "/events"
+
+	// DefaultTimeout bounds a call to swan
+	DefaultTimeout = 10 * time.Second
+	// DefaultRetries is how many times an idempotent call is retried when
+	// swan is unavailable
+	DefaultRetries = 2
+	// DefaultBackoff is waited before the first retry, then doubled
+	DefaultBackoff = 500 * time.Millisecond
+)
+
+// Client calls the api of a swan cluster
+type Client struct {
+	host    string
+	http    *http.Client
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTimeout bounds every call but the event stream
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithRetries sets how many times the idempotent calls are retried when
+// swan is unavailable, and the backoff before the first retry
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithHTTPClient replaces the underlying http client
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// New returns a client of the swan api at host, e.g. http://swan:9999, the
+// scheme defaults to http
+func New(host string, opts ...Option) (*Client, error) {
+	// a host:port without scheme would parse as a scheme
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("swan address %s has no host", host)
+	}
+
+	c := &Client{
+		host:    u.String(),
+		http:    &http.Client{},
+		timeout: DefaultTimeout,
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Host returns the address of swan
+func (c *Client) Host() string {
+	return c.host
+}
+
+// Error is a response of swan which is not successful
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("swan %s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Body)
+}
+
+// Reply is the answer of swan to a change, the status is zero when swan
+// could not be reached
+type Reply struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+// UnavailableError is returned when swan can not be reached or answers
+// that it is unavailable
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return "swan is unavailable: " + e.Err.Error()
+}
+
+// IsNotFound reports whether err tells that the app or version does not
+// exist in swan
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// IsUnavailable reports whether err is an outage of swan rather than an
+// answer
+func IsUnavailable(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
+// appPath returns the path of an app in the swan api followed by elem,
+// every segment is escaped so an id can not reach another path
+func appPath(appId string, elem ...string) string {
+	path := Apps + "/" + url.PathEscape(appId)
+	for _, e := range elem {
+		path += "/" + url.PathEscape(e)
+	}
+	return path
+}
+
+// GetApp returns the app with its tasks and versions
+func (c *Client) GetApp(ctx context.Context, appId string) (types.App, error) {
+	var app types.App
+	_, err := c.call(ctx, "GET", appPath(appId), nil, &app, true)
+	return app, err
+}
+
+// ListVersions returns the versions of an app
+func (c *Client) ListVersions(ctx context.Context, appId string) ([]types.Version, error) {
+	var versions []types.Version
+	_, err := c.call(ctx, "GET", appPath(appId, "versions"), nil, &versions, true)
+	return versions, err
+}
+
+// GetVersion returns a version of an app
+func (c *Client) GetVersion(ctx context.Context, appId, versionId string) (types.Version, error) {
+	var version types.Version
+	_, err := c.call(ctx, "GET", appPath(appId, "versions", versionId), nil, &version, true)
+	return version, err
+}
+
+// UpdateApp submits a new version of an app, swan updates its first
+// instances and waits for ProceedUpdate
+func (c *Client) UpdateApp(ctx context.Context, appId string, version types.Version) (Reply, error) {
+	return c.call(ctx, "PUT", appPath(appId), version, nil, false)
+}
+
+// ProceedUpdate updates the given number of instances more to the proposed
+// version of an app
+func (c *Client) ProceedUpdate(ctx context.Context, appId string, instances int64) (Reply, error) {
+	return c.call(ctx, "PATCH", appPath(appId, "proceed-update"),
+		map[string]int64{"instances": instances}, nil, false)
+}
+
+// CancelUpdate rolls the updated instances of an app back to its current
+// version
+func (c *Client) CancelUpdate(ctx context.Context, appId string) (Reply, error) {
+	return c.call(ctx, "PATCH", appPath(appId, "cancel-update"), nil, nil, false)
+}
+
+// ScaleApp changes the number of instances of an app
+func (c *Client) ScaleApp(ctx context.Context, appId string, instances int64) (Reply, error) {
+	return c.call(ctx, "PATCH", appPath(appId, "scale"),
+		map[string]int64{"instances": instances}, nil, false)
+}
+
+// Events opens the event stream of swan, it is only bounded by ctx and the
+// caller must close it
+func (c *Client) Events(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", c.host+Events, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, &UnavailableError{Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp.Body, nil
+}
+
+// call sends body as json and decodes the response into out, or returns it
+// as the reply when out is nil. Idempotent calls are retried with backoff
+// while swan is unavailable.
+func (c *Client) call(ctx context.Context, method, path string, body, out interface{}, idempotent bool) (Reply, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return Reply{Method: method, Path: path}, err
+		}
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		reply, err := c.do(ctx, method, path, data, out)
+		if err == nil || !idempotent || !IsUnavailable(err) || attempt >= c.retries {
+			return reply, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return reply, &UnavailableError{Err: ctx.Err()}
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, data []byte, out interface{}) (Reply, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	reply := Reply{Method: method, Path: path}
+	req, err := http.NewRequest(method, c.host+path, bytes.NewReader(data))
+	if err != nil {
+		return reply, err
+	}
+	req = req.WithContext(ctx)
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return reply, &UnavailableError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	reply.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || out == nil {
+		reply.Body = readBody(resp)
+		return reply, replyError(reply)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return reply, fmt.Errorf("swan %s %s: decode response: %v", method, path, err)
+	}
+	return reply, nil
+}
+
+// readBody returns the beginning of a response
+func readBody(resp *http.Response) string {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return string(bytes.TrimSpace(data))
+}
+
+func responseError(resp *http.Response) error {
+	return replyError(Reply{
+		Method:     resp.Request.Method,
+		Path:       resp.Request.URL.Path,
+		StatusCode: resp.StatusCode,
+		Body:       readBody(resp),
+	})
+}
+
+// replyError returns the error of a reply, nil when it is successful
+func replyError(reply Reply) error {
+	if reply.StatusCode >= 200 && reply.StatusCode < 300 {
+		return nil
+	}
+	err := &Error{Method: reply.Method, Path: reply.Path, StatusCode: reply.StatusCode, Body: reply.Body}
+	switch reply.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &UnavailableError{Err: err}
+	}
+	return err
+}
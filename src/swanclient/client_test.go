@@ -0,0 +1,54 @@
+package swanclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewDefaultsScheme(t *testing.T) {
+	for host, want := range map[string]string{
+		"localhost:5198":        "http://localhost:5198",
+		"127.0.0.1:5198":        "http://127.0.0.1:5198",
+		"swan":                  "http://swan",
+		"https://swan.io:9999":  "https://swan.io:9999",
+		"http://localhost:5198": "http://localhost:5198",
+	} {
+		c, err := New(host)
+		if err != nil {
+			t.Errorf("New(%q): %v", host, err)
+			continue
+		}
+		if c.Host() != want {
+			t.Errorf("New(%q) host %s, want %s", host, c.Host(), want)
+		}
+	}
+	if _, err := New("http://"); err == nil {
+		t.Error("New accepted an address without host")
+	}
+}
+
+func TestPathSegmentsEscaped(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	c, err := New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.GetVersion(context.Background(), "web/../../x", "1?v=2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CancelUpdate(context.Background(), "web#x"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{Apps + "/web%2F..%2F..%2Fx/versions/1%3Fv=2", Apps + "/web%23x/cancel-update"}
+	if len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("swan called on %q, want %q", paths, want)
+	}
+}
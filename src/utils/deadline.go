@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type serverWriterKey struct{}
+
+// KeepServerWriter makes the response writer of the server reachable from
+// the requests h handles, the gin writer does not unwrap to it. The streams
+// move the write deadline of the server through it.
+func KeepServerWriter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverWriterKey{}, w)))
+	})
+}
+
+// SetWriteDeadline moves the write deadline of the response to r, which the
+// WriteTimeout of the server sets when the request is read, a zero time
+// clears it
+func SetWriteDeadline(r *http.Request, deadline time.Time) error {
+	w, ok := r.Context().Value(serverWriterKey{}).(http.ResponseWriter)
+	if !ok {
+		return errors.New("the response writer of the server is not kept")
+	}
+	return http.NewResponseController(w).SetWriteDeadline(deadline)
+}